@@ -0,0 +1,159 @@
+package botcmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"tgfreesub/cmd/extract"
+	"tgfreesub/cmd/store"
+	"tgfreesub/cmd/tg"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	latestDefault   = 5
+	latestMax       = 10
+	searchResults   = 5
+	searchMaxPages  = 5 // 搜索一页可能没有结果，最多继续扫描的页数
+	itemPreviewRune = 300
+)
+
+var linkTypes = []extract.LinkType{
+	extract.LinkUrl, extract.LinkVmess, extract.LinkVless, extract.LinkSS, extract.LinkSSR,
+	extract.LinkTrojan, extract.LinkHysteria2, extract.LinkTuic,
+}
+
+type commands struct {
+	publicUrl string
+	channels  func() []string
+}
+
+// Register 注册查询命令；publicUrl 为web服务的外部访问地址，用于生成订阅链接，channels 返回监控的频道
+func Register(b *tg.TgBot, publicUrl string, channels func() []string) {
+	c := &commands{publicUrl: strings.TrimRight(publicUrl, "/"), channels: channels}
+	b.WithCommand("latest", "最新的消息，如 /latest 5", c.latest).
+		WithCommand("search", "搜索消息，如 /search 机场 订阅", c.search).
+		WithCommand("channels", "监控的频道及消息数", c.listChannels).
+		WithCommand("stats", "统计信息", c.stats).
+		WithCommand("sub", "汇总的订阅链接，可指定频道 /sub a,b", c.sub)
+}
+
+func (c *commands) latest(ctx context.Context, cmd *tg.TgBotCmd) string {
+	n := int64(latestDefault)
+	if cmd.Args != "" {
+		fmt.Sscanf(cmd.Args, "%d", &n)
+	}
+	n = min(max(n, 1), latestMax)
+
+	_, items := store.QuerySubItems(ulid.Make().String(), 0, n)
+	if len(items) == 0 {
+		return "暂无消息"
+	}
+	return formatItems(items)
+}
+
+func (c *commands) search(ctx context.Context, cmd *tg.TgBotCmd) string {
+	if cmd.Args == "" {
+		return "用法：/search 关键词"
+	}
+	rid := ulid.Make().String()
+
+	var cursor int64
+	found := []store.SubItem{}
+	for range searchMaxPages {
+		nxt, items := store.SearchItems(rid, cmd.Args, cursor, int64(searchResults-len(found)))
+		found = append(found, items...)
+		if nxt < 0 || len(found) >= searchResults || ctx.Err() != nil {
+			break
+		}
+		cursor = nxt
+	}
+	if len(found) == 0 {
+		return "没有找到：" + cmd.Args
+	}
+	return formatItems(found)
+}
+
+func (c *commands) listChannels(ctx context.Context, cmd *tg.TgBotCmd) string {
+	channels := c.channels()
+	if len(channels) == 0 {
+		return "没有监控的频道"
+	}
+	lines := []string{}
+	for _, ch := range channels {
+		// 私有频道的名字是邀请码，bot可能对所有人开放，只显示标题；消息按去掉+的名字索引
+		name := ch
+		if strings.HasPrefix(ch, "+") {
+			name = privateTitle(ch)
+		}
+		lines = append(lines, fmt.Sprintf("%s  %d条", name, store.CountByChannel(strings.TrimPrefix(ch, "+"))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// privateTitle 私有频道的标题，取登记信息，没有时取最新一条消息的频道名
+func privateTitle(ch string) string {
+	if conf, ok := store.GetChannel(ch); ok && conf.Title != "" {
+		return conf.Title + "(私有)"
+	}
+	f := &store.ItemFilter{Channels: []string{strings.TrimPrefix(ch, "+")}}
+	if _, items := store.QueryItemsBy(ulid.Make().String(), f, 0, 1); len(items) > 0 && items[0].ChannelName != "" {
+		return items[0].ChannelName + "(私有)"
+	}
+	return "私有频道"
+}
+
+func (c *commands) stats(ctx context.Context, cmd *tg.TgBotCmd) string {
+	rid := ulid.Make().String()
+	lines := []string{
+		fmt.Sprintf("消息总数：%d", store.GetItemsTotal(rid)),
+		fmt.Sprintf("监控频道：%d", len(c.channels())),
+	}
+	if _, items := store.QuerySubItems(rid, 0, 1); len(items) > 0 {
+		lines = append(lines, "最新消息："+time.Unix(items[0].PubDate, 0).Format(time.DateTime))
+	}
+	lines = append(lines, "包含链接的消息数：")
+	for _, t := range linkTypes {
+		if n := store.CountByLinkType(string(t)); n > 0 {
+			lines = append(lines, fmt.Sprintf("  %s  %d", t, n))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *commands) sub(ctx context.Context, cmd *tg.TgBotCmd) string {
+	chs := []string{}
+	for _, ch := range strings.FieldsFunc(cmd.Args, func(r rune) bool { return r == ',' || r == '，' || r == ' ' }) {
+		chs = append(chs, url.QueryEscape(strings.TrimPrefix(ch, "@")))
+	}
+	query := ""
+	if len(chs) > 0 {
+		query = "?channel=" + strings.Join(chs, ",")
+	}
+	return strings.Join([]string{
+		"v2ray：" + c.publicUrl + "/subs/nodes" + query,
+		"clash：" + c.publicUrl + "/subs/clash" + query,
+		"sing-box：" + c.publicUrl + "/subs/singbox" + query,
+	}, "\n")
+}
+
+// formatItems 消息列表的文本格式：频道、时间、内容摘要、原消息链接
+func formatItems(items []store.SubItem) string {
+	parts := []string{}
+	for i := range items {
+		item := &items[i]
+		text := strings.TrimSpace(store.PlainText(item.MsgContent))
+		if utf8.RuneCountInString(text) > itemPreviewRune {
+			text = string([]rune(text)[:itemPreviewRune]) + "…"
+		}
+		parts = append(parts, fmt.Sprintf("【%s】%s\n%s\nhttps://%s/%s",
+			item.ChannelName, time.Unix(item.PubDate, 0).Format("01-02 15:04"), text,
+			item.ChannelUrl, strconv.FormatInt(item.Msgid, 10)))
+	}
+	return strings.Join(parts, "\n\n")
+}
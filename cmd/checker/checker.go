@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"context"
+	"slices"
+	"tgfreesub/cmd/fetcher"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const checkPageSize int64 = 50
+
+// Checker 定期重新抓取已入库消息中的订阅链接，记录检测结果并更新消息的订阅状态
+type Checker struct {
+	fetcher  *fetcher.Fetcher
+	interval time.Duration // 检测周期
+	window   time.Duration // 只检测该时间内发布的消息
+}
+
+func NewChecker(f *fetcher.Fetcher, interval, window time.Duration) *Checker {
+	return &Checker{
+		fetcher:  f,
+		interval: interval,
+		window:   window,
+	}
+}
+
+// Start 启动后台检测协程，启动后先检测一轮
+func (c *Checker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			c.CheckOnce(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// CheckOnce 从新到旧检测一轮
+func (c *Checker) CheckOnce(ctx context.Context) {
+	rid := ulid.Make().String()
+	since := time.Now().Add(-c.window).Unix()
+
+	var cursor int64
+	var checked, alive int
+	for ctx.Err() == nil {
+		nxt, items := store.QuerySubItems(rid, cursor, checkPageSize)
+		for i := range items {
+			item := &items[i]
+			if item.PubDate < since {
+				nxt = -1
+				break
+			}
+			if !slices.ContainsFunc(item.Links, fetcher.IsSubUrl) {
+				continue
+			}
+			if c.checkItem(ctx, rid, item) == store.StatusAlive {
+				alive++
+			}
+			checked++
+		}
+		if nxt < 0 || len(items) == 0 {
+			break
+		}
+		cursor = nxt
+	}
+	logs.Info().Rid(rid).Int("checked", checked).Int("alive", alive).Msg("check subs done")
+}
+
+func (c *Checker) checkItem(ctx context.Context, rid string, item *store.SubItem) string {
+	member := item.Member()
+	now := time.Now().Unix()
+
+	// 按消息中的订阅链接检测，首次抓取失败或抓取任务被丢弃的链接也会被检测到
+	olds := map[string]store.SubFetch{}
+	for _, sub := range item.Subs {
+		olds[sub.Url] = sub
+	}
+
+	status := store.StatusDead
+	subs := store.SubFetchList{}
+	for _, l := range item.Links {
+		if !fetcher.IsSubUrl(l) {
+			continue
+		}
+		rec := c.checkUrl(ctx, l.Url, now)
+		if err := store.AddCheckRecord(rid, member, &rec.CheckRecord); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Msg("AddCheckRecord fail")
+		}
+		status = betterStatus(status, rec.Status)
+
+		if rec.Status == store.StatusDead { // 抓取失败时保留上次的结果
+			if old, ok := olds[l.Url]; ok {
+				subs = append(subs, old)
+			}
+			continue
+		}
+		subs = append(subs, store.SubFetch{
+			Url:       l.Url,
+			Format:    rec.format,
+			Nodes:     rec.nodes,
+			Upload:    rec.Upload,
+			Download:  rec.Download,
+			Total:     rec.Total,
+			Expire:    rec.Expire,
+			FetchedAt: now,
+		})
+	}
+
+	if status != store.StatusDead {
+		if err := store.SetItemSubs(rid, member, subs); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Msg("SetItemSubs fail")
+		}
+	}
+	if err := store.SetItemStatus(rid, member, status, now); err != nil {
+		logs.Warn(err).Rid(rid).Str("member", member).Msg("SetItemStatus fail")
+	}
+	logs.Debug().Rid(rid).Str("member", member).Str("status", status).Msg("check item")
+	return status
+}
+
+type checkResult struct {
+	store.CheckRecord
+	format string
+	nodes  []string
+}
+
+func (c *Checker) checkUrl(ctx context.Context, subUrl string, now int64) *checkResult {
+	res := &checkResult{CheckRecord: store.CheckRecord{Url: subUrl, CheckedAt: now}}
+	code, sf, err := c.fetcher.Probe(ctx, subUrl)
+	res.HttpStatus = code
+	if err != nil {
+		res.Status, res.Error = store.StatusDead, err.Error()
+		return res
+	}
+
+	res.format, res.nodes = sf.Format, sf.Nodes
+	res.Nodes = len(sf.Nodes)
+	res.Upload, res.Download, res.Total, res.Expire = sf.Upload, sf.Download, sf.Total, sf.Expire
+	res.Status = SubStatus(sf, now)
+	return res
+}
+
+// SubStatus 根据抓取结果判断订阅状态：过期或流量用完为 expired，没有节点为 dead
+func SubStatus(sf *store.SubFetch, now int64) string {
+	if sf.Expire > 0 && sf.Expire < now {
+		return store.StatusExpired
+	}
+	if sf.Total > 0 && sf.Upload+sf.Download >= sf.Total {
+		return store.StatusExpired
+	}
+	if len(sf.Nodes) == 0 {
+		return store.StatusDead
+	}
+	return store.StatusAlive
+}
+
+// 一条消息有多个订阅时，取最好的状态
+func betterStatus(a, b string) string {
+	rank := map[string]int{store.StatusDead: 0, store.StatusExpired: 1, store.StatusAlive: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,225 @@
+package discovery
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"time"
+)
+
+// Ref 消息中引用的频道
+type Ref struct {
+	Key       string
+	Name      string
+	ChannelID int64
+	Title     string
+	Fwd       bool // 转发来源，否则为@提及或t.me链接
+}
+
+var (
+	reMention = regexp.MustCompile(`(?:^|[^\w@./])@([A-Za-z][A-Za-z0-9_]{4,31})\b`)
+	reTme     = regexp.MustCompile(`(?i)(?:https?://)?(?:t|telegram)\.me/(?:s/)?(\+[\w-]{8,}|joinchat/[\w-]{8,}|[A-Za-z][A-Za-z0-9_]{4,31})\b`)
+)
+
+// t.me 下不是频道的路径
+var reservedPaths = map[string]bool{
+	"share": true, "proxy": true, "socks": true, "addstickers": true, "addemoji": true, "addtheme": true,
+	"addlist": true, "setlanguage": true, "login": true, "contact": true, "confirmphone": true, "boost": true,
+	"joinchat": true,
+}
+
+// ExtractRefs 提取文本及链接中 @username、t.me/xxx、t.me/+邀请码 形式的频道引用，bot(以bot结尾)除外
+func ExtractRefs(text string, urls ...string) []Ref {
+	refs := []Ref{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if inv, ok := strings.CutPrefix(name, "joinchat/"); ok {
+			name = "+" + inv
+		}
+		key := strings.ToLower(name)
+		if seen[key] || reservedPaths[key] || (!strings.HasPrefix(key, "+") && strings.HasSuffix(key, "bot")) {
+			return
+		}
+		seen[key] = true
+		refs = append(refs, Ref{Key: key, Name: name})
+	}
+
+	all := text + "\n" + strings.Join(urls, "\n")
+	for _, m := range reMention.FindAllStringSubmatch(all, -1) {
+		add(m[1])
+	}
+	for _, m := range reTme.FindAllStringSubmatch(all, -1) {
+		add(m[1])
+	}
+	return refs
+}
+
+// FwdRef 转发来源频道的引用，没有username时以频道id为key
+func FwdRef(chanid int64, name, title string) Ref {
+	ref := Ref{Name: name, ChannelID: chanid, Title: title, Fwd: true}
+	if name != "" {
+		ref.Key = strings.ToLower(name)
+	} else {
+		ref.Key = "id:" + strconv.FormatInt(chanid, 10)
+	}
+	return ref
+}
+
+var recordMu sync.Mutex
+
+// Record 记录监控的频道 src 的一条消息中引用的频道，kept 表示该消息是否通过了过滤规则
+func Record(src string, srcID, msgid int64, refs []Ref, kept bool) {
+	if len(refs) == 0 {
+		return
+	}
+	src = strings.ToLower(src)
+	monitored := cachedMonitoredChannels()
+
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	now := time.Now().Unix()
+	for _, ref := range refs {
+		if ref.Key == src || (ref.ChannelID != 0 && ref.ChannelID == srcID) {
+			continue // 引用自己
+		}
+		if monitored.has(&ref) {
+			continue
+		}
+
+		c, ok := store.GetCandidate(ref.Key)
+		if !ok {
+			c = store.Candidate{Key: ref.Key, FirstSeen: now}
+		}
+		if c.Sources == nil {
+			c.Sources = map[string][]int64{}
+		}
+		ids, ok := addMsgid(c.Sources[src], msgid)
+		if !ok {
+			continue // 重复拉取的消息
+		}
+		c.Sources[src] = ids
+		c.Name = cmp.Or(c.Name, ref.Name)
+		c.Title = cmp.Or(ref.Title, c.Title)
+		c.ChannelID = cmp.Or(c.ChannelID, ref.ChannelID)
+		c.Seen++
+		if kept {
+			c.Hits++
+		}
+		if ref.Fwd {
+			c.Fwds++
+		} else {
+			c.Mentions++
+		}
+		c.LastSeen = now
+		if err := store.SaveCandidate(&c); err != nil {
+			logs.Warn(err).Str("key", c.Key).Msg("SaveCandidate fail")
+		}
+	}
+}
+
+// 每个来源频道保留的已统计msgid数
+const maxSourceMsgids = 256
+
+// addMsgid 把msgid加入升序的已统计列表，已统计过时返回false；
+// 历史消息是从新到旧拉取的，不能只比较最大msgid；列表满时比保留的都旧的消息视为已统计过
+func addMsgid(ids []int64, msgid int64) ([]int64, bool) {
+	i, found := slices.BinarySearch(ids, msgid)
+	if found || (i == 0 && len(ids) >= maxSourceMsgids) {
+		return ids, false
+	}
+	ids = slices.Insert(ids, i, msgid)
+	if len(ids) > maxSourceMsgids {
+		ids = slices.Delete(ids, 0, len(ids)-maxSourceMsgids)
+	}
+	return ids, true
+}
+
+type channelSet map[string]bool
+
+// 每条消息都要判断引用的频道是否已在监控，缓存一段时间，避免每次都读取全部登记
+const monitoredCacheTTL = 30 * time.Second
+
+var monitoredCache struct {
+	sync.Mutex
+	set channelSet
+	at  time.Time
+}
+
+func cachedMonitoredChannels() channelSet {
+	monitoredCache.Lock()
+	defer monitoredCache.Unlock()
+	if monitoredCache.set == nil || time.Since(monitoredCache.at) >= monitoredCacheTTL {
+		monitoredCache.set, monitoredCache.at = monitoredChannels(), time.Now()
+	}
+	return monitoredCache.set
+}
+
+// monitoredChannels 已登记的频道，key为小写的频道名(私有频道去掉+)及 id:<频道id>
+func monitoredChannels() channelSet {
+	res := channelSet{}
+	for _, c := range store.GetChannels() {
+		res[strings.ToLower(strings.TrimPrefix(c.Name, "+"))] = true
+		if c.ChannelID != 0 {
+			res["id:"+strconv.FormatInt(c.ChannelID, 10)] = true
+		}
+	}
+	return res
+}
+
+func (cs channelSet) has(ref *Ref) bool {
+	return cs[strings.TrimPrefix(ref.Key, "+")] || (ref.ChannelID != 0 && cs["id:"+strconv.FormatInt(ref.ChannelID, 10)])
+}
+
+// Ranked 排序后的候选频道
+type Ranked struct {
+	store.Candidate
+	HitRate float64 `json:"hit_rate"` // 引用它的消息中通过过滤规则的比例
+	Score   float64 `json:"score"`
+}
+
+// Candidates 按得分从高到低返回候选频道，已忽略、已加入监控及出现次数少于minSeen的不返回
+// 得分 = 命中数 × 命中率 × (1 + 0.5 × (来源频道数-1))，被多个频道引用且内容相关的排在前面
+func Candidates(minSeen int64, number int) []Ranked {
+	monitored := monitoredChannels()
+	res := []Ranked{}
+	for _, c := range store.GetCandidates() {
+		if c.Status != "" || c.Seen < max(minSeen, 1) {
+			continue
+		}
+		if monitored.has(&Ref{Key: c.Key, ChannelID: c.ChannelID}) {
+			continue
+		}
+		r := Ranked{Candidate: c, HitRate: float64(c.Hits) / float64(c.Seen)}
+		r.Score = float64(c.Hits) * r.HitRate * (1 + 0.5*float64(max(len(c.Sources)-1, 0)))
+		res = append(res, r)
+	}
+	slices.SortFunc(res, func(a, b Ranked) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(b.Seen, a.Seen), cmp.Compare(b.LastSeen, a.LastSeen))
+	})
+	if number > 0 && len(res) > number {
+		res = res[:number]
+	}
+	return res
+}
+
+// SetStatus 标记候选频道为已忽略或已加入监控
+func SetStatus(key, status string) (store.Candidate, bool) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	c, ok := store.GetCandidate(key)
+	if !ok {
+		return c, false
+	}
+	c.Status = status
+	if err := store.SaveCandidate(&c); err != nil {
+		logs.Warn(err).Str("key", key).Msg("SaveCandidate fail")
+		return c, false
+	}
+	return c, true
+}
@@ -0,0 +1,91 @@
+package extract
+
+import (
+	"regexp"
+	"strings"
+)
+
+type LinkType string
+
+const (
+	LinkUrl       LinkType = "url" // http(s)订阅链接
+	LinkVmess     LinkType = "vmess"
+	LinkVless     LinkType = "vless"
+	LinkSS        LinkType = "ss"
+	LinkSSR       LinkType = "ssr"
+	LinkTrojan    LinkType = "trojan"
+	LinkHysteria2 LinkType = "hysteria2"
+	LinkTuic      LinkType = "tuic"
+)
+
+type Link struct {
+	Type LinkType `json:"type"`
+	Url  string   `json:"url"`
+}
+
+var (
+	// 分享链接中可能包含 # 备注，备注里常有中文，所以只以空白和引号作为结束
+	reShareLink = regexp.MustCompile(`(?i)\b(vmess|vless|ssr|ss|trojan|hysteria2|hy2|tuic)://[^\s<>"'` + "`" + `]+`)
+	reHttpLink  = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'` + "`" + `\p{Han}，。、；！？（）【】「」《》]+`)
+
+	// 这些域名是频道/群组链接，不是订阅
+	skipHosts = []string{"t.me/", "telegram.me/", "telegram.dog/"}
+)
+
+// 分享链接末尾常见的中英文标点
+const trimCutset = ".,;:!?)]}>'\"，。；：！？）】》"
+
+// Parse 从消息文本中提取订阅链接与节点分享链接，
+// extras 为消息文本之外的链接（如隐藏超链接、按钮链接），一并解析
+func Parse(text string, extras ...string) []Link {
+	links := []Link{}
+	seen := map[string]bool{}
+
+	add := func(lt LinkType, u string) {
+		u = strings.TrimRight(u, trimCutset)
+		if u == "" || seen[u] {
+			return
+		}
+		seen[u] = true
+		links = append(links, Link{Type: lt, Url: u})
+	}
+
+	for _, s := range append([]string{text}, extras...) {
+		for _, m := range reShareLink.FindAllStringSubmatch(s, -1) {
+			add(shareLinkType(m[1]), m[0])
+		}
+		for _, u := range reHttpLink.FindAllString(s, -1) {
+			if isSkipHost(u) {
+				continue
+			}
+			add(LinkUrl, u)
+		}
+	}
+	return links
+}
+
+func shareLinkType(scheme string) LinkType {
+	switch scheme = strings.ToLower(scheme); scheme {
+	case "hy2":
+		return LinkHysteria2
+	default:
+		return LinkType(scheme)
+	}
+}
+
+func isSkipHost(u string) bool {
+	_, after, _ := strings.Cut(u, "://")
+	after = strings.ToLower(after)
+	after = strings.TrimPrefix(after, "www.")
+	for _, h := range skipHosts {
+		if strings.HasPrefix(after, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsShareLink 是否为可直接导入客户端的节点分享链接
+func (l Link) IsShareLink() bool {
+	return l.Type != LinkUrl
+}
@@ -0,0 +1,67 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// https://www.jsonfeed.org/version/1.1/
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageUrl string         `json:"home_page_url,omitempty"`
+	FeedUrl     string         `json:"feed_url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	Url           string           `json:"url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHtml   string           `json:"content_html"`
+	DatePublished string           `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Ext           any              `json:"_tgfreesub,omitempty"` // 自定义扩展，以下划线开头
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// ToJSONFeed 生成 JSON Feed 1.1，Item.Ext 输出为 _tgfreesub 扩展字段
+func ToJSONFeed(f *Feed) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageUrl: f.Link,
+		FeedUrl:     f.SelfLink,
+		Description: f.Description,
+		Items:       []jsonFeedItem{},
+	}
+	for _, it := range f.Items {
+		ji := jsonFeedItem{
+			ID:            it.ID,
+			Url:           it.Link,
+			Title:         it.Title,
+			ContentHtml:   it.Content,
+			DatePublished: it.Published.UTC().Format(time.RFC3339),
+			Tags:          it.Tags,
+			Ext:           it.Ext,
+		}
+		if it.Author != "" {
+			ji.Authors = []jsonFeedAuthor{{Name: it.Author}}
+		}
+		doc.Items = append(doc.Items, ji)
+	}
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // content_html 中的标签保持原样，方便阅读
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,329 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"tgfreesub/cmd/extract"
+	"tgfreesub/cmd/nodes"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"tgfreesub/internal/netguard"
+	"time"
+)
+
+var (
+	ErrFetchStatus   = errors.New("fetch status not ok")
+	ErrFormatUnknown = errors.New("subscription format unknown")
+	ErrForbiddenAddr = netguard.ErrForbiddenAddr // 内网、回环等地址，不允许抓取
+)
+
+const (
+	FormatBase64  = "base64"
+	FormatClash   = "clash"
+	FormatSingBox = "singbox"
+	FormatPlain   = "plain"
+)
+
+const maxBodySize = 8 << 20
+
+type fetchJob struct {
+	rid  string
+	item *store.SubItem
+}
+
+// Fetcher 下载消息中的订阅链接，解析出节点列表与流量信息
+type Fetcher struct {
+	UserAgent string
+
+	client  *http.Client
+	jobs    chan fetchJob
+	dropped atomic.Int64 // 队列满时丢弃的任务数
+}
+
+// NewFetcher proxy 为空时直连，支持 socks5://、http:// 代理，不带scheme时按socks5处理；
+// 链接来自频道消息，不可信，连接内网、回环、链路本地地址的请求(包括重定向后的)都会被拒绝
+func NewFetcher(proxy string) *Fetcher {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: netguard.Control}
+	tr.DialContext = dialer.DialContext
+	tr.Proxy = nil
+	if proxy != "" {
+		if !strings.Contains(proxy, "://") {
+			proxy = "socks5://" + proxy
+		}
+		if u, err := url.Parse(proxy); err != nil {
+			logs.Warn(err).Str("proxy", proxy).Msg("parse proxy fail")
+		} else {
+			// 代理本身常在本机，不做限制；目标地址由代理解析，请求前先在本地解析检查，解析失败的也不抓取
+			dialer.Control = nil
+			tr.Proxy = func(req *http.Request) (*url.URL, error) {
+				if err := netguard.CheckHost(req.Context(), req.URL.Hostname()); err != nil {
+					return nil, err
+				}
+				return u, nil
+			}
+		}
+	}
+
+	return &Fetcher{
+		UserAgent: "v2rayN/6.45",
+		client:    &http.Client{Transport: tr, Timeout: 20 * time.Second},
+		jobs:      make(chan fetchJob, 256),
+	}
+}
+
+// WithClient 替换http客户端，主要用于测试
+func (f *Fetcher) WithClient(c *http.Client) *Fetcher {
+	f.client = c
+	return f
+}
+
+// Start 启动后台抓取协程
+func (f *Fetcher) Start(ctx context.Context, workers int) {
+	for range max(workers, 1) {
+		go f.work(ctx)
+	}
+}
+
+// Dropped 队列满时丢弃的任务总数
+func (f *Fetcher) Dropped() int64 {
+	return f.dropped.Load()
+}
+
+// Enqueue 提交新入库的消息，队列满时直接丢弃
+func (f *Fetcher) Enqueue(rid string, item *store.SubItem) {
+	if !hasSubUrl(item) {
+		return
+	}
+	select {
+	case f.jobs <- fetchJob{rid: rid, item: item}:
+	default:
+		n := f.dropped.Add(1)
+		logs.Warn(nil).Rid(rid).Str("member", item.Member()).Int64("dropped", n).Msg("fetch queue full, job dropped")
+	}
+}
+
+func (f *Fetcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-f.jobs:
+			f.fetchItem(ctx, job.rid, job.item)
+		}
+	}
+}
+
+func (f *Fetcher) fetchItem(ctx context.Context, rid string, item *store.SubItem) {
+	subs := store.SubFetchList{}
+	for _, l := range item.Links {
+		if !IsSubUrl(l) {
+			continue
+		}
+		res, err := f.Fetch(ctx, l.Url)
+		if err != nil {
+			logs.Debug().Rid(rid).Str("url", l.Url).Err(err).Msg("fetch sub fail")
+			continue
+		}
+		logs.Info().Rid(rid).Str("url", l.Url).Str("format", res.Format).Int("nodes", len(res.Nodes)).Msg("fetch sub succ")
+		subs = append(subs, *res)
+	}
+
+	if len(subs) == 0 {
+		return
+	}
+	if err := store.SetItemSubs(rid, item.Member(), subs); err != nil {
+		logs.Warn(err).Rid(rid).Str("member", item.Member()).Msg("SetItemSubs fail")
+	}
+}
+
+// Fetch 下载一个订阅链接，识别格式并解析出节点
+func (f *Fetcher) Fetch(ctx context.Context, subUrl string) (*store.SubFetch, error) {
+	_, res, err := f.Probe(ctx, subUrl)
+	return res, err
+}
+
+// Probe 同 Fetch，额外返回http状态码，请求未发出时为0
+func (f *Fetcher) Probe(ctx context.Context, subUrl string) (int, *store.SubFetch, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subUrl, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil, fmt.Errorf("%w: %d", ErrFetchStatus, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+
+	format, ns, err := ParseBody(body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+
+	res := &store.SubFetch{
+		Url:       subUrl,
+		Format:    format,
+		Nodes:     ns,
+		FetchedAt: time.Now().Unix(),
+	}
+	parseUserInfo(resp.Header.Get("subscription-userinfo"), res)
+	return resp.StatusCode, res, nil
+}
+
+// ParseBody 识别订阅内容格式，返回节点分享链接
+func ParseBody(body []byte) (string, []string, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return "", nil, ErrFormatUnknown
+	}
+
+	if body[0] == '{' { // sing-box
+		var probe map[string]json.RawMessage
+		if json.Unmarshal(body, &probe) == nil && probe["outbounds"] != nil {
+			ns, err := nodes.FromSingBox(body)
+			return FormatSingBox, toLinks(ns), err
+		}
+	}
+
+	if bytes.Contains(body, []byte("proxies:")) { // clash
+		if ns, err := nodes.FromClash(body); err == nil && len(ns) > 0 {
+			return FormatClash, toLinks(ns), nil
+		}
+	}
+
+	if links := shareLinks(string(body)); len(links) > 0 {
+		return FormatPlain, links, nil
+	}
+
+	if data, err := decodeBase64(string(body)); err == nil {
+		if links := shareLinks(string(data)); len(links) > 0 {
+			return FormatBase64, links, nil
+		}
+	}
+
+	return "", nil, ErrFormatUnknown
+}
+
+func shareLinks(text string) []string {
+	links := []string{}
+	for _, l := range extract.Parse(text) {
+		if l.IsShareLink() {
+			links = append(links, l.Url)
+		}
+	}
+	return links
+}
+
+func toLinks(ns []*nodes.Node) []string {
+	links := []string{}
+	for _, n := range ns {
+		if link := n.Link(); link != "" {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "") // 有的订阅按76字符换行
+	s = strings.TrimRight(s, "=")
+	if data, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return data, nil
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// subscription-userinfo: upload=1234; download=2234; total=1024000; expire=2218532293
+func parseUserInfo(header string, res *store.SubFetch) {
+	for _, kv := range strings.Split(header, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		switch strings.ToLower(k) {
+		case "upload":
+			res.Upload = n
+		case "download":
+			res.Download = n
+		case "total":
+			res.Total = n
+		case "expire":
+			res.Expire = n
+		}
+	}
+}
+
+func hasSubUrl(item *store.SubItem) bool {
+	return slices.ContainsFunc(item.Links, IsSubUrl)
+}
+
+// 明显不是订阅的链接：网页、图片、安装包，注册/登录页面，常见的非订阅网站
+var (
+	skipExts  = []string{".html", ".htm", ".jpg", ".jpeg", ".png", ".gif", ".webp", ".svg", ".mp4", ".apk", ".exe", ".dmg", ".ipa", ".zip", ".pdf", ".css", ".js"}
+	skipPaths = []string{"register", "signup", "sign-up", "login", "signin"}
+	skipHosts = []string{"youtube.com", "youtu.be", "google.com", "twitter.com", "x.com", "facebook.com", "instagram.com", "wikipedia.org", "apple.com", "play.google.com"}
+)
+
+// IsSubUrl 是否可能是订阅链接，只有这些链接才会被抓取
+func IsSubUrl(l extract.Link) bool {
+	if l.Type != extract.LinkUrl {
+		return false
+	}
+	u, err := url.Parse(l.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip, err := netip.ParseAddr(host); err == nil && netguard.Forbidden(ip) {
+		return false
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") {
+		return false
+	}
+	for _, h := range skipHosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return false
+		}
+	}
+
+	p := strings.ToLower(u.Path)
+	if strings.Trim(p, "/") == "" && u.RawQuery == "" { // 网站首页
+		return false
+	}
+	for _, ext := range skipExts {
+		if strings.HasSuffix(p, ext) {
+			return false
+		}
+	}
+	for _, kw := range skipPaths {
+		if strings.Contains(p, kw) || strings.Contains(strings.ToLower(u.Fragment), kw) {
+			return false
+		}
+	}
+	return true
+}
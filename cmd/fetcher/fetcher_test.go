@@ -0,0 +1,175 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"tgfreesub/cmd/extract"
+	"tgfreesub/cmd/store"
+)
+
+const (
+	testTrojan = "trojan://pass@a.example.com:443?sni=a.example.com#node-a"
+	testSS     = "ss://YWVzLTI1Ni1nY206cGFzcw@b.example.com:8388#node-b"
+)
+
+const testClash = `
+proxies:
+  - name: node-a
+    type: trojan
+    server: a.example.com
+    port: 443
+    password: pass
+    sni: a.example.com
+  - name: node-b
+    type: ss
+    server: b.example.com
+    port: 8388
+    cipher: aes-256-gcm
+    password: pass
+`
+
+const testSingBox = `{
+  "outbounds": [
+    {"type": "trojan", "tag": "node-a", "server": "a.example.com", "server_port": 443, "password": "pass",
+     "tls": {"enabled": true, "server_name": "a.example.com"}},
+    {"type": "shadowsocks", "tag": "node-b", "server": "b.example.com", "server_port": 8388, "method": "aes-256-gcm", "password": "pass"},
+    {"type": "selector", "tag": "select", "outbounds": ["node-a", "node-b"]},
+    {"type": "direct", "tag": "direct"}
+  ]
+}`
+
+func testServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	body := func(s string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(s))
+		}
+	}
+	plain := testTrojan + "\n" + testSS + "\n"
+	mux.HandleFunc("/plain", body(plain))
+	mux.HandleFunc("/base64", body(base64.StdEncoding.EncodeToString([]byte(plain))))
+	mux.HandleFunc("/clash", body(testClash))
+	mux.HandleFunc("/singbox", body(testSingBox))
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("subscription-userinfo", "upload=1024; download=2048; total=1073741824; expire=2218532293")
+		w.Write([]byte(plain))
+	})
+	mux.HandleFunc("/html", body("<html><body>hello</body></html>"))
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchFormats(t *testing.T) {
+	srv := testServer(t)
+	f := NewFetcher("").WithClient(srv.Client())
+
+	cases := []struct {
+		path   string
+		format string
+	}{
+		{"/plain", FormatPlain},
+		{"/base64", FormatBase64},
+		{"/clash", FormatClash},
+		{"/singbox", FormatSingBox},
+	}
+	for _, c := range cases {
+		res, err := f.Fetch(context.Background(), srv.URL+c.path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		if res.Format != c.format {
+			t.Errorf("%s: format = %q, want %q", c.path, res.Format, c.format)
+		}
+		if len(res.Nodes) != 2 {
+			t.Errorf("%s: nodes = %v, want 2", c.path, res.Nodes)
+		}
+		if res.Url != srv.URL+c.path || res.FetchedAt == 0 {
+			t.Errorf("%s: url = %q, fetched_at = %d", c.path, res.Url, res.FetchedAt)
+		}
+	}
+}
+
+func TestFetchUserInfo(t *testing.T) {
+	srv := testServer(t)
+	f := NewFetcher("").WithClient(srv.Client())
+
+	res, err := f.Fetch(context.Background(), srv.URL+"/userinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Upload != 1024 || res.Download != 2048 || res.Total != 1<<30 || res.Expire != 2218532293 {
+		t.Errorf("userinfo = %+v", res)
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	srv := testServer(t)
+	f := NewFetcher("").WithClient(srv.Client())
+
+	code, _, err := f.Probe(context.Background(), srv.URL+"/gone")
+	if code != http.StatusGone || !errors.Is(err, ErrFetchStatus) {
+		t.Errorf("non-200: code = %d, err = %v", code, err)
+	}
+	code, _, err = f.Probe(context.Background(), srv.URL+"/html")
+	if code != http.StatusOK || !errors.Is(err, ErrFormatUnknown) {
+		t.Errorf("unknown body: code = %d, err = %v", code, err)
+	}
+}
+
+func TestForbiddenAddr(t *testing.T) {
+	srv := testServer(t)
+	f := NewFetcher("")
+
+	code, _, err := f.Probe(context.Background(), srv.URL+"/plain")
+	if code != 0 || !errors.Is(err, ErrForbiddenAddr) {
+		t.Errorf("loopback: code = %d, err = %v", code, err)
+	}
+}
+
+func TestIsSubUrl(t *testing.T) {
+	cases := map[string]bool{
+		"https://sub.example.com/api/v1/client/subscribe?token=abc": true,
+		"https://sub.example.com/link/abc?sub=3":                    true,
+		"https://raw.githubusercontent.com/a/b/main/sub.txt":        true,
+		"https://example.com/":                                      false,
+		"https://example.com":                                       false,
+		"https://example.com/#/register?code=abc":                   false,
+		"https://example.com/auth/register?code=abc":                false,
+		"https://example.com/a.png":                                 false,
+		"https://www.youtube.com/watch?v=abc":                       false,
+		"http://127.0.0.1:8080/sub":                                 false,
+		"http://192.168.1.1/sub":                                    false,
+		"http://localhost/sub":                                      false,
+	}
+	for u, want := range cases {
+		if got := IsSubUrl(extract.Link{Type: extract.LinkUrl, Url: u}); got != want {
+			t.Errorf("%s: got %v, want %v", u, got, want)
+		}
+	}
+	if IsSubUrl(extract.Link{Type: extract.LinkTrojan, Url: testTrojan}) {
+		t.Error("share link should not be fetched")
+	}
+}
+
+func TestEnqueueDropped(t *testing.T) {
+	f := NewFetcher("")
+	for i := range cap(f.jobs) + 3 {
+		f.Enqueue("rid", &store.SubItem{
+			ChannelUrl: "test",
+			Msgid:      int64(i),
+			Links:      store.LinkList{{Type: extract.LinkUrl, Url: "https://sub.example.com/s?token=" + strconv.Itoa(i)}},
+		})
+	}
+	if n := f.Dropped(); n != 3 {
+		t.Errorf("dropped = %d, want 3", n)
+	}
+}
@@ -0,0 +1,191 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"tgfreesub/cmd/extract"
+	"tgfreesub/cmd/store"
+	"unicode/utf8"
+)
+
+// Rule 单条过滤规则，未设置的条件不参与判断
+type Rule struct {
+	Include      []string `json:"include,omitempty"`       // 包含任一关键词即保留
+	Exclude      []string `json:"exclude,omitempty"`       // 包含任一关键词即丢弃
+	Regex        []string `json:"regex,omitempty"`         // 匹配任一正则即保留
+	ExcludeRegex []string `json:"exclude_regex,omitempty"` // 匹配任一正则即丢弃
+	RequireLinks []string `json:"require_links,omitempty"` // 至少包含其中一种链接：url,vmess,vless,ss,ssr,trojan,hysteria2,tuic
+	MinLength    *int     `json:"min_length,omitempty"`    // 消息最少字符数
+
+	reInclude []*regexp.Regexp
+	reExclude []*regexp.Regexp
+}
+
+// Rules 全局规则，Channels 中按频道名设置的字段会覆盖全局规则的同名字段；
+// 频道名不区分大小写，可以带 t.me/、@ 前缀，私有频道可以带 +
+type Rules struct {
+	Rule
+	Channels map[string]*Rule `json:"channels,omitempty"`
+}
+
+// Default 未配置规则文件时的默认规则
+func Default() *Rules {
+	return &Rules{
+		Rule: Rule{Include: []string{"机场", "订阅", "节点"}},
+	}
+}
+
+// Load 从json文件加载规则
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &Rules{}
+	if err := json.Unmarshal(data, rules); err != nil {
+		return nil, err
+	}
+	if err := rules.Compile(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Compile 预编译正则，并把全局规则合并进每个频道的规则
+func (rs *Rules) Compile() error {
+	if err := rs.Rule.Compile(); err != nil {
+		return err
+	}
+	for name, r := range rs.Channels {
+		merged := rs.Rule.override(r)
+		if err := merged.Compile(); err != nil {
+			return fmt.Errorf("channel %s: %w", name, err)
+		}
+		rs.Channels[name] = merged
+	}
+	return nil
+}
+
+// Match 使用频道对应的规则判断是否保留，返回判断原因
+func (rs *Rules) Match(item *store.SubItem) (bool, string) {
+	if r := rs.channelRule(item.ChannelUrl); r != nil {
+		keep, reason := r.Match(item)
+		return keep, "channel " + item.ChannelUrl + ": " + reason
+	}
+	return rs.Rule.Match(item)
+}
+
+// channelRule 查找频道的规则，频道名去掉前缀后不区分大小写比较
+func (rs *Rules) channelRule(name string) *Rule {
+	name = channelKey(name)
+	for k, r := range rs.Channels {
+		if strings.EqualFold(channelKey(k), name) {
+			return r
+		}
+	}
+	return nil
+}
+
+func channelKey(name string) string {
+	return strings.TrimPrefix(store.NormChannelName(name), "+")
+}
+
+func (r *Rule) Compile() error {
+	var err error
+	if r.reInclude, err = compileAll(r.Regex); err != nil {
+		return err
+	}
+	r.reExclude, err = compileAll(r.ExcludeRegex)
+	return err
+}
+
+func compileAll(exprs []string) ([]*regexp.Regexp, error) {
+	res := []*regexp.Regexp{}
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("regex %q: %w", expr, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// 频道规则中设置了的字段覆盖全局规则，设置为空列表(min_length 设为0)表示清除全局规则中的该项；ch 为nil时沿用全局规则
+func (r Rule) override(ch *Rule) *Rule {
+	if ch == nil {
+		return &r
+	}
+	if ch.Include != nil {
+		r.Include = ch.Include
+	}
+	if ch.Exclude != nil {
+		r.Exclude = ch.Exclude
+	}
+	if ch.Regex != nil {
+		r.Regex = ch.Regex
+	}
+	if ch.ExcludeRegex != nil {
+		r.ExcludeRegex = ch.ExcludeRegex
+	}
+	if ch.RequireLinks != nil {
+		r.RequireLinks = ch.RequireLinks
+	}
+	if ch.MinLength != nil {
+		r.MinLength = ch.MinLength
+	}
+	return &r
+}
+
+// Match 判断消息是否保留，返回判断原因
+func (r *Rule) Match(item *store.SubItem) (bool, string) {
+	text := item.MsgContent
+	for _, b := range item.Buttons { // 按钮文字也参与过滤
+		text += "\n" + b.Text
+	}
+	lower := strings.ToLower(text)
+
+	if r.MinLength != nil {
+		if n := utf8.RuneCountInString(text); n < *r.MinLength {
+			return false, fmt.Sprintf("too short: %d < %d", n, *r.MinLength)
+		}
+	}
+	for _, kw := range r.Exclude {
+		if strings.Contains(lower, strings.ToLower(kw)) {
+			return false, "exclude keyword: " + kw
+		}
+	}
+	for _, re := range r.reExclude {
+		if re.MatchString(text) {
+			return false, "exclude regex: " + re.String()
+		}
+	}
+
+	if len(r.RequireLinks) > 0 {
+		found := slices.ContainsFunc(item.Links, func(l extract.Link) bool {
+			return slices.Contains(r.RequireLinks, string(l.Type))
+		})
+		if !found {
+			return false, "no required link: " + strings.Join(r.RequireLinks, ",")
+		}
+	}
+
+	if len(r.Include) == 0 && len(r.reInclude) == 0 {
+		return true, "no include rule"
+	}
+	for _, kw := range r.Include {
+		if strings.Contains(lower, strings.ToLower(kw)) {
+			return true, "include keyword: " + kw
+		}
+	}
+	for _, re := range r.reInclude {
+		if re.MatchString(text) {
+			return true, "include regex: " + re.String()
+		}
+	}
+	return false, "no include keyword/regex matched"
+}
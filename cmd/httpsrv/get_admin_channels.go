@@ -0,0 +1,186 @@
+package httpsrv
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"tgfreesub/cmd/store"
+	"tgfreesub/cmd/tg"
+	"tgfreesub/internal/logs"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// ChannelRunner 运行时增删频道的接收协程，由 tg.TgSuber 实现
+type ChannelRunner interface {
+	AddChannel(name string) (tg.SubChannelInfo, error)
+	RemoveChannel(name string) bool
+	Channels() []tg.SubChannelInfo
+}
+
+var (
+	channelRunner ChannelRunner
+	adminToken    string
+)
+
+func SetChannelRunner(r ChannelRunner) {
+	channelRunner = r
+}
+
+// SetAdminToken 设置管理接口的token，为空时管理接口不可用
+func SetAdminToken(token string) {
+	adminToken = token
+}
+
+type AdminChannel struct {
+	store.ChannelConf
+	Running bool `json:"running"`
+}
+
+type AdminChannelsResp struct {
+	Rtn      int            `json:"rtn"`
+	Msg      string         `json:"msg,omitempty"`
+	Channels []AdminChannel `json:"channels,omitempty"`
+}
+
+// checkAdmin 校验管理权限，失败时回复错误
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" || channelRunner == nil {
+		http.Error(w, "admin api disabled", http.StatusForbidden)
+		return false
+	}
+	if !isAdmin(r) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// isAdmin 校验 Authorization: Bearer <token> 或参数 token
+func isAdmin(r *http.Request) bool {
+	if adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// GET /admin/channels
+// 列出登记的频道及是否正在接收消息
+func HndAdminChannelList(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	rid := ulid.Make().String()
+	replyJson(w, rid, &AdminChannelsResp{Msg: "succ", Channels: adminChannels()})
+}
+
+// POST /admin/channels?name=xxx
+// 添加频道并立即开始接收消息，私有频道为 +邀请码(需要已加入)；已暂停的频道会被恢复
+func HndAdminChannelAdd(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	rid := ulid.Make().String()
+	name := store.NormChannelName(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+
+	conf, _ := store.GetChannel(name)
+	conf.Name = name
+	replyAdmin(w, rid, r, name, activateChannel(rid, &conf))
+}
+
+// POST /admin/channels/{name}/pause  停止接收，保留PTS，恢复后补齐暂停期间的消息
+// POST /admin/channels/{name}/resume
+func HndAdminChannelOp(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	rid := ulid.Make().String()
+	name := store.NormChannelName(r.PathValue("name"))
+	conf, ok := store.GetChannel(name)
+	if !ok {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	switch r.PathValue("op") {
+	case "pause":
+		channelRunner.RemoveChannel(name)
+		conf.Status = store.ChannelPaused
+		err = store.SaveChannel(&conf)
+	case "resume":
+		err = activateChannel(rid, &conf)
+	default:
+		http.Error(w, "unknown op", http.StatusNotFound)
+		return
+	}
+	replyAdmin(w, rid, r, name, err)
+}
+
+// DELETE /admin/channels/{name}
+// 停止接收并删除登记，已保存的消息不删除
+func HndAdminChannelDel(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	rid := ulid.Make().String()
+	name := store.NormChannelName(r.PathValue("name"))
+	if _, ok := store.GetChannel(name); !ok {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	channelRunner.RemoveChannel(name)
+	replyAdmin(w, rid, r, name, store.DelChannel(name))
+}
+
+// activateChannel 启动频道的接收协程，并登记为 active
+func activateChannel(rid string, conf *store.ChannelConf) error {
+	sci, err := channelRunner.AddChannel(conf.Name)
+	if err != nil {
+		logs.Warn(err).Rid(rid).Str("name", conf.Name).Msg("AddChannel fail")
+		return err
+	}
+	conf.Status = store.ChannelActive
+	conf.Title = sci.Title
+	conf.ChannelID = sci.ChannelID
+	return store.SaveChannel(conf)
+}
+
+func replyAdmin(w http.ResponseWriter, rid string, r *http.Request, name string, err error) {
+	resp := &AdminChannelsResp{Msg: "succ"}
+	if err != nil {
+		resp.Rtn, resp.Msg = -1, err.Error()
+		if errors.Is(err, tg.ErrNotReady) {
+			resp.Msg = "tg client not ready, retry later"
+		}
+	} else {
+		resp.Channels = adminChannels()
+	}
+	logs.Info().Rid(rid).Str("name", name).Int("rtn", resp.Rtn).Str("msg", resp.Msg).Str(r.Method, r.URL.Path).Send()
+	replyJson(w, rid, resp)
+}
+
+func adminChannels() []AdminChannel {
+	running := channelRunner.Channels()
+	res := []AdminChannel{}
+	for _, c := range store.GetChannels() {
+		ac := AdminChannel{ChannelConf: c}
+		for _, sci := range running {
+			if (c.ChannelID != 0 && sci.ChannelID == c.ChannelID) || strings.EqualFold(sci.Name, strings.TrimPrefix(c.Name, "+")) {
+				ac.Running = true
+				break
+			}
+		}
+		res = append(res, ac)
+	}
+	return res
+}
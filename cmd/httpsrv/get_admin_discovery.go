@@ -0,0 +1,80 @@
+package httpsrv
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"tgfreesub/cmd/discovery"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+
+	"github.com/oklog/ulid/v2"
+)
+
+type AdminDiscoveryResp struct {
+	Rtn        int                `json:"rtn"`
+	Msg        string             `json:"msg,omitempty"`
+	Candidates []discovery.Ranked `json:"candidates,omitempty"`
+}
+
+// GET /admin/discovery?number=50&min_seen=2
+// 被监控频道转发或提及、但还没有监控的频道，按得分从高到低排序
+func HndAdminDiscoveryList(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	rid := ulid.Make().String()
+
+	number, minSeen := 50, int64(1)
+	q := r.URL.Query()
+	if s := q.Get("number"); s != "" {
+		fmt.Sscanf(s, "%d", &number)
+	}
+	if s := q.Get("min_seen"); s != "" {
+		fmt.Sscanf(s, "%d", &minSeen)
+	}
+
+	resp := &AdminDiscoveryResp{Msg: "succ", Candidates: discovery.Candidates(minSeen, number)}
+	logs.Info().Rid(rid).Int("number", number).Int64("min_seen", minSeen).
+		Int("candidates", len(resp.Candidates)).Str(r.Method, r.URL.Path).Send()
+	replyJson(w, rid, resp)
+}
+
+// POST /admin/discovery/{key}/promote  加入监控，同 POST /admin/channels?name=xxx
+// POST /admin/discovery/{key}/ignore   忽略，不再列出
+func HndAdminDiscoveryOp(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	rid := ulid.Make().String()
+	key := strings.ToLower(r.PathValue("key"))
+	c, ok := store.GetCandidate(key)
+	if !ok {
+		http.Error(w, "candidate not found", http.StatusNotFound)
+		return
+	}
+
+	resp := &AdminDiscoveryResp{Msg: "succ"}
+	switch r.PathValue("op") {
+	case "promote":
+		if c.Name == "" {
+			resp.Rtn, resp.Msg = -1, "no username, add it by invite link"
+			break
+		}
+		conf, _ := store.GetChannel(c.Name)
+		conf.Name = c.Name
+		if err := activateChannel(rid, &conf); err != nil {
+			resp.Rtn, resp.Msg = -1, err.Error()
+			break
+		}
+		discovery.SetStatus(key, store.CandidatePromoted)
+	case "ignore":
+		discovery.SetStatus(key, store.CandidateIgnored)
+	default:
+		http.Error(w, "unknown op", http.StatusNotFound)
+		return
+	}
+
+	logs.Info().Rid(rid).Str("key", key).Int("rtn", resp.Rtn).Str("msg", resp.Msg).Str(r.Method, r.URL.Path).Send()
+	replyJson(w, rid, resp)
+}
@@ -0,0 +1,239 @@
+package httpsrv
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+	"tgfreesub/cmd/extract"
+	"tgfreesub/cmd/feed"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oklog/ulid/v2"
+)
+
+type SubsFeedReq struct {
+	number   int64    // 最多输出的条数
+	channels []string // 只取这些频道的消息
+	keywords []string // 需同时包含这些关键词(不区分大小写)
+}
+
+const (
+	feedTitle        = "免费节点订阅"
+	feedDescription  = "每日自动抓取订阅、机场优惠信息"
+	feedMaxNumber    = 200
+	feedScanMax      = 1000 // 带过滤条件时最多扫描的消息条数
+	feedTitleMaxRune = 60
+)
+
+// GET /subs/feed.xml?number=50&channel=a,b&q=机场 订阅
+// RSS 2.0 订阅源，条目链接指向 https://t.me/<channel>/<msgid>
+// channel: 只取指定频道，多个用逗号分隔；q: 关键词，多个用空格分隔，需同时包含
+func HndSubsFeedRss(w http.ResponseWriter, r *http.Request) {
+	hndSubsFeed(w, r, feed.ToRSS, "application/rss+xml; charset=utf-8")
+}
+
+// GET /subs/atom.xml?number=50&channel=a,b&q=机场
+// 参数同 /subs/feed.xml，返回 Atom 1.0
+func HndSubsFeedAtom(w http.ResponseWriter, r *http.Request) {
+	hndSubsFeed(w, r, feed.ToAtom, "application/atom+xml; charset=utf-8")
+}
+
+// GET /subs/feed.json?number=50&channel=a,b&q=机场
+// 参数同 /subs/feed.xml，返回 JSON Feed 1.1，条目的 _tgfreesub 字段包含频道、msgid与提取到的链接
+func HndSubsFeedJson(w http.ResponseWriter, r *http.Request) {
+	hndSubsFeed(w, r, feed.ToJSONFeed, "application/feed+json; charset=utf-8")
+}
+
+// GET /channels/{name}/feed.json、/channels/{name}/feed.xml、/channels/{name}/atom.xml
+// 单个频道的订阅源，参数同 /subs/feed.xml(channel参数无效)
+func HndChannelFeed(w http.ResponseWriter, r *http.Request) {
+	switch r.PathValue("file") {
+	case "feed.json":
+		hndSubsFeed(w, r, feed.ToJSONFeed, "application/feed+json; charset=utf-8")
+	case "feed.xml":
+		hndSubsFeed(w, r, feed.ToRSS, "application/rss+xml; charset=utf-8")
+	case "atom.xml":
+		hndSubsFeed(w, r, feed.ToAtom, "application/atom+xml; charset=utf-8")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// FeedItemExt JSON Feed 条目的 _tgfreesub 扩展字段
+type FeedItemExt struct {
+	Channel string         `json:"channel"`
+	Msgid   int64          `json:"msgid"`
+	Links   store.LinkList `json:"links,omitempty"`
+	Sources []string       `json:"sources,omitempty"`
+	Status  string         `json:"status,omitempty"`
+}
+
+func hndSubsFeed(w http.ResponseWriter, r *http.Request, render func(*feed.Feed) ([]byte, error), contentType string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := ulid.Make().String()
+	req := parseSubsFeedReq(r)
+
+	items := collectFeedItems(rid, req)
+	body, err := render(buildFeed(r, req, items))
+	if err != nil {
+		logs.Warn(err).Rid(rid).Msg("render feed fail")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logs.Info().Rid(rid).Int64("number", req.number).Strs("channels", req.channels).Strs("keywords", req.keywords).
+		Int("items", len(items)).Str(r.Method, r.URL.Path).Send()
+
+	replyText(w, contentType, body)
+}
+
+func parseSubsFeedReq(r *http.Request) *SubsFeedReq {
+	req := &SubsFeedReq{
+		number: 50,
+	}
+
+	q := r.URL.Query()
+	if numberStr := q.Get("number"); numberStr != "" {
+		fmt.Sscanf(numberStr, "%d", &req.number)
+	}
+	req.number = min(max(req.number, 1), feedMaxNumber)
+	req.channels = splitParam(q.Get("channel"))
+	if name := r.PathValue("name"); name != "" { // /channels/{name}/...
+		req.channels = []string{strings.TrimPrefix(name, "t.me/")}
+	}
+	req.keywords = strings.Fields(strings.ToLower(q.Get("q")))
+	return req
+}
+
+// collectFeedItems 从新到旧取符合条件的消息，指定频道时使用频道索引
+func collectFeedItems(rid string, req *SubsFeedReq) []store.SubItem {
+	res := []store.SubItem{}
+	filter := &store.ItemFilter{Channels: req.channels}
+	var cursor int64
+	for pages := 0; pages < feedScanMax/int(subsNodesPageSize) && int64(len(res)) < req.number; pages++ {
+		nxt, items := store.QueryItemsBy(rid, filter, cursor, subsNodesPageSize)
+		for _, item := range items {
+			if len(req.keywords) > 0 {
+				text := strings.ToLower(store.PlainText(item.MsgContent))
+				if slices.ContainsFunc(req.keywords, func(kw string) bool { return !strings.Contains(text, kw) }) {
+					continue
+				}
+			}
+			if res = append(res, item); int64(len(res)) >= req.number {
+				break
+			}
+		}
+		if nxt < 0 {
+			break
+		}
+		cursor = nxt
+	}
+	return res
+}
+
+func buildFeed(r *http.Request, req *SubsFeedReq, items []store.SubItem) *feed.Feed {
+	base := requestBaseUrl(r)
+	f := &feed.Feed{
+		Title:       feedTitle,
+		Link:        base + "/",
+		SelfLink:    base + r.URL.RequestURI(),
+		Description: feedDescription,
+		Updated:     time.Now(),
+	}
+	switch {
+	case r.PathValue("name") != "" && len(items) > 0:
+		f.Title = items[0].ChannelName
+		f.Link = "https://" + items[0].ChannelUrl
+	case len(req.channels) > 0:
+		f.Title += " - " + strings.Join(req.channels, ",")
+	}
+	if len(items) > 0 {
+		f.Updated = time.Unix(items[0].PubDate, 0)
+	}
+	for i := range items {
+		f.Items = append(f.Items, feedItem(&items[i]))
+	}
+	return f
+}
+
+func feedItem(item *store.SubItem) feed.Item {
+	link := "https://" + item.ChannelUrl + "/" + fmt.Sprint(item.Msgid)
+	it := feed.Item{
+		ID:        link,
+		Title:     feedItemTitle(item),
+		Link:      link,
+		Content:   feedItemContent(item),
+		Author:    item.ChannelName,
+		Published: time.Unix(item.PubDate, 0),
+	}
+	ext := &FeedItemExt{
+		Channel: strings.TrimPrefix(item.ChannelUrl, "t.me/"),
+		Msgid:   item.Msgid,
+		Links:   item.Links,
+		Status:  item.Status,
+	}
+	for _, src := range item.Sources {
+		ext.Sources = append(ext.Sources, "https://"+src.ChannelUrl+"/"+fmt.Sprint(src.Msgid))
+	}
+	it.Ext = ext
+	for _, l := range item.Links {
+		if !slices.Contains(it.Tags, string(l.Type)) {
+			it.Tags = append(it.Tags, string(l.Type))
+		}
+	}
+	return it
+}
+
+// 标题取消息的第一行非空文本
+func feedItemTitle(item *store.SubItem) string {
+	title := ""
+	for _, line := range strings.Split(store.PlainText(item.MsgContent), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			title = line
+			break
+		}
+	}
+	if utf8.RuneCountInString(title) > feedTitleMaxRune {
+		title = string([]rune(title)[:feedTitleMaxRune]) + "…"
+	}
+	if title == "" {
+		title = item.ChannelName
+	}
+	return title
+}
+
+// 正文为渲染后的消息内容，后面附上提取到的链接
+func feedItemContent(item *store.SubItem) string {
+	sb := strings.Builder{}
+	sb.WriteString("<p>" + strings.ReplaceAll(item.MsgContent, "</ p>", "<br/>") + "</p>")
+	if len(item.Links) > 0 {
+		sb.WriteString("<ul>")
+		for _, l := range item.Links {
+			u := html.EscapeString(l.Url)
+			if l.Type == extract.LinkUrl {
+				sb.WriteString(`<li><a href="` + u + `">` + u + `</a></li>`)
+			} else {
+				sb.WriteString("<li><code>" + u + "</code></li>")
+			}
+		}
+		sb.WriteString("</ul>")
+	}
+	return sb.String()
+}
+
+// 反向代理时通过 X-Forwarded-Proto 识别https
+func requestBaseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
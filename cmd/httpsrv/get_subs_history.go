@@ -0,0 +1,46 @@
+package httpsrv
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+
+	"github.com/oklog/ulid/v2"
+)
+
+type SubsHistoryResp struct {
+	Rtn     int                 `json:"rtn"`
+	Msg     string              `json:"msg,omitempty"`
+	Records []store.CheckRecord `json:"records"`
+}
+
+// GET /subs/history?channel=xxx&msgid=123
+// 查询一条消息中订阅链接的检测记录，按时间从新到旧
+func HndSubsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := ulid.Make().String()
+
+	q := r.URL.Query()
+	channel := strings.TrimPrefix(q.Get("channel"), "t.me/")
+	var msgid int64
+	fmt.Sscanf(q.Get("msgid"), "%d", &msgid)
+	if channel == "" || msgid <= 0 {
+		http.Error(w, "channel and msgid required", http.StatusBadRequest)
+		return
+	}
+
+	item := store.SubItem{ChannelUrl: channel, Msgid: msgid}
+	resp := &SubsHistoryResp{
+		Msg:     "succ",
+		Records: store.GetCheckRecords(rid, item.Member()),
+	}
+	logs.Info().Rid(rid).Str("member", item.Member()).Int("records", len(resp.Records)).Str(r.Method, r.URL.Path).Send()
+
+	replyJson(w, rid, resp)
+}
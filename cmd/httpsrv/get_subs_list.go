@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"tgfreesub/cmd/store"
 	"tgfreesub/internal/logs"
 
@@ -13,6 +14,7 @@ import (
 type SubsListReq struct {
 	offset int64
 	number int64
+	filter store.ItemFilter
 }
 type SubsListResp struct {
 	Rtn   int    `json:"rtn"`
@@ -27,6 +29,10 @@ type SubsListResp struct {
 // offset=0 表示查询最新消息
 // 接口支持分页查询，响应中的offset为下一页的起始位置
 // 当响应中的offset < 0时表示已经查询完成
+// 可选过滤参数：
+// channel=a,b 只取指定频道；since/until 发布时间范围(unix秒，含边界)；
+// has=vmess,trojan,url 只取包含任一类型链接的消息
+// 带过滤参数时一页的结果可能少于number条，继续翻页即可
 func HndSubsList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		// logs.Warn(nil).Str("method", r.Method).Msg("unsupport")
@@ -51,17 +57,35 @@ func HndSubsList(w http.ResponseWriter, r *http.Request) {
 	if numberStr != "" {
 		fmt.Sscanf(numberStr, "%d", &req.number)
 	}
+	req.filter.Channels = splitParam(q.Get("channel"))
+	req.filter.LinkTypes = splitParam(q.Get("has"))
+	if sinceStr := q.Get("since"); sinceStr != "" {
+		fmt.Sscanf(sinceStr, "%d", &req.filter.Since)
+	}
+	if untilStr := q.Get("until"); untilStr != "" {
+		fmt.Sscanf(untilStr, "%d", &req.filter.Until)
+	}
 
-	logs.Info().Rid(rid).Int64("offset", req.offset).Int64("number", req.number).Str(r.Method, r.URL.Path).Send()
+	logs.Info().Rid(rid).Int64("offset", req.offset).Int64("number", req.number).
+		Strs("channels", req.filter.Channels).Strs("has", req.filter.LinkTypes).
+		Int64("since", req.filter.Since).Int64("until", req.filter.Until).Str(r.Method, r.URL.Path).Send()
 
 	resp := &SubsListResp{
 		Rtn:    0,
 		Msg:    "succ",
 		Offset: -1,
-		Total:  store.GetItemsTotal(rid),
 	}
 
-	nxt, items := store.QuerySubItems(rid, req.offset, req.number)
+	// total 为符合过滤条件的消息数
+	var nxt int64
+	var items []store.SubItem
+	if f := &req.filter; !f.Empty() {
+		resp.Total = store.CountItemsBy(f)
+		nxt, items = store.QueryItemsBy(rid, f, req.offset, req.number)
+	} else {
+		resp.Total = store.GetItemsTotal(rid)
+		nxt, items = store.QuerySubItems(rid, req.offset, req.number)
+	}
 	if items != nil {
 		resp.Offset = nxt
 		resp.Itmes = items
@@ -70,6 +94,17 @@ func HndSubsList(w http.ResponseWriter, r *http.Request) {
 	replyJson(w, rid, resp)
 }
 
+// a,b,c 拆分为列表，忽略空项
+func splitParam(s string) []string {
+	res := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
 func replyJson(w http.ResponseWriter, _ string, j any) error {
 	// logs.Debug().Rid(rid).Msgf("resp:%+v", j)
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,329 @@
+package httpsrv
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"tgfreesub/cmd/nodes"
+	"tgfreesub/cmd/prober"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+type SubsNodesReq struct {
+	number    int64    // 最多扫描的消息条数
+	channels  []string // 只取这些频道的消息
+	since     int64    // 只取该时间之后发布的消息
+	sort      string   // latency: 按检测延迟从低到高排序
+	maxDelay  int64    // 只取延迟不超过该值(毫秒)的节点
+	reachable bool     // 只取检测可连通的节点
+}
+
+var nodeProber *prober.Prober
+
+// SetNodeProber 设置节点检测器，未设置时忽略 sort/maxdelay/reachable 参数
+func SetNodeProber(p *prober.Prober) {
+	nodeProber = p
+}
+
+func (req *SubsNodesReq) needProbe() bool {
+	return nodeProber != nil && (req.sort == "latency" || req.maxDelay > 0 || req.reachable)
+}
+
+const (
+	subsNodesPageSize  int64 = 50
+	subsNodesMaxNumber int64 = 2000 // number 参数的上限
+)
+
+// 一次请求中检测节点的总时长上限，超时未检测的节点视为不可连通
+const probeTimeout = 20 * time.Second
+
+// 检测会向所有节点发起连接，匿名请求同时只允许一个，且两次之间至少间隔 probeInterval；带管理token的请求不限制
+const probeInterval = 30 * time.Second
+
+var probeLimit struct {
+	sync.Mutex
+	running bool
+	last    time.Time
+}
+
+// startProbe 需要检测节点时检查频率限制，超过限制时回复429；返回的函数在检测结束后调用
+func startProbe(w http.ResponseWriter, r *http.Request, rid string, req *SubsNodesReq) (func(), bool) {
+	if !req.needProbe() || isAdmin(r) {
+		return func() {}, true
+	}
+
+	probeLimit.Lock()
+	defer probeLimit.Unlock()
+	wait := time.Until(probeLimit.last.Add(probeInterval))
+	if probeLimit.running || wait > 0 {
+		logs.Info().Rid(rid).Str("from", r.RemoteAddr).Dur("wait", wait).Msg("probe rate limited")
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(wait, time.Second).Seconds())))
+		http.Error(w, "probe rate limited, retry later", http.StatusTooManyRequests)
+		return nil, false
+	}
+	probeLimit.running = true
+	return func() {
+		probeLimit.Lock()
+		probeLimit.running, probeLimit.last = false, time.Now()
+		probeLimit.Unlock()
+	}, true
+}
+
+// GET /subs/nodes?number=200&channel=a,b&age=24h
+// 汇总最近number条消息中的节点分享链接，去重后按v2ray订阅格式(base64)返回
+// 客户端可直接将该地址作为订阅地址
+// channel: 只取指定频道，多个用逗号分隔
+// age: 只取最近一段时间的消息，如 12h、3d，纯数字表示小时
+// sort=latency: 按tcp/tls建连延迟从低到高排序
+// maxdelay=800: 只取延迟不超过800毫秒的节点，reachable=1: 只取可连通的节点
+// 需要检测时匿名请求有频率限制，超过时返回429
+func HndSubsNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := ulid.Make().String()
+	req := parseSubsNodesReq(r)
+	done, ok := startProbe(w, r, rid, req)
+	if !ok {
+		return
+	}
+	defer done()
+
+	links := collectShareLinks(rid, req)
+	logs.Info().Rid(rid).Int64("number", req.number).Strs("channels", req.channels).Int64("since", req.since).
+		Int("nodes", len(links)).Str(r.Method, r.URL.Path).Send()
+
+	body := base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+	replyText(w, "text/plain; charset=utf-8", []byte(body))
+}
+
+// GET /subs/clash?number=200&channel=a,b&age=24h
+// 参数同 /subs/nodes，返回 Clash/Mihomo 配置文件
+func HndSubsClash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := ulid.Make().String()
+	req := parseSubsNodesReq(r)
+	done, ok := startProbe(w, r, rid, req)
+	if !ok {
+		return
+	}
+	defer done()
+
+	ns := collectNodes(rid, req)
+	conf, err := nodes.ToClash(ns)
+	if err != nil {
+		logs.Warn(err).Rid(rid).Msg("ToClash fail")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logs.Info().Rid(rid).Int64("number", req.number).Strs("channels", req.channels).Int64("since", req.since).
+		Int("nodes", len(ns)).Str(r.Method, r.URL.Path).Send()
+
+	replyText(w, "text/yaml; charset=utf-8", conf)
+}
+
+// GET /subs/singbox?number=200&channel=a,b&age=24h
+// 参数同 /subs/nodes，返回 sing-box 配置文件
+func HndSubsSingbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := ulid.Make().String()
+	req := parseSubsNodesReq(r)
+	done, ok := startProbe(w, r, rid, req)
+	if !ok {
+		return
+	}
+	defer done()
+
+	ns := collectNodes(rid, req)
+	conf, err := nodes.ToSingBox(ns)
+	if err != nil {
+		logs.Warn(err).Rid(rid).Msg("ToSingBox fail")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logs.Info().Rid(rid).Int64("number", req.number).Strs("channels", req.channels).Int64("since", req.since).
+		Int("nodes", len(ns)).Str(r.Method, r.URL.Path).Send()
+
+	replyText(w, "application/json; charset=utf-8", conf)
+}
+
+func parseSubsNodesReq(r *http.Request) *SubsNodesReq {
+	req := &SubsNodesReq{
+		number: 200,
+	}
+
+	q := r.URL.Query()
+	if numberStr := q.Get("number"); numberStr != "" {
+		fmt.Sscanf(numberStr, "%d", &req.number)
+	}
+	req.number = min(max(req.number, 1), subsNodesMaxNumber)
+	if chans := splitParam(q.Get("channel")); len(chans) > 0 {
+		req.channels = chans
+	}
+	if age := parseAge(q.Get("age")); age > 0 {
+		req.since = time.Now().Add(-age).Unix()
+	}
+	req.sort = q.Get("sort")
+	if delayStr := q.Get("maxdelay"); delayStr != "" {
+		fmt.Sscanf(delayStr, "%d", &req.maxDelay)
+	}
+	req.reachable = q.Get("reachable") == "1"
+	return req
+}
+
+// 12h、30m、3d，纯数字表示小时
+func parseAge(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if h, err := strconv.Atoi(s); err == nil {
+		return time.Duration(h) * time.Hour
+	}
+	if d, ok := strings.CutSuffix(s, "d"); ok {
+		if days, err := strconv.Atoi(d); err == nil {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	age, _ := time.ParseDuration(s)
+	return age
+}
+
+// scanSubItems 从新到旧遍历符合条件的消息，指定频道时使用频道索引
+func scanSubItems(rid string, req *SubsNodesReq, fn func(item *store.SubItem)) {
+	filter := &store.ItemFilter{Channels: req.channels, Since: req.since}
+	var cursor, scanned int64
+	for scanned < req.number {
+		nxt, items := store.QueryItemsBy(rid, filter, cursor, min(subsNodesPageSize, req.number-scanned))
+		for i := range items {
+			fn(&items[i])
+		}
+		scanned += int64(len(items))
+		if nxt < 0 || len(items) == 0 {
+			break
+		}
+		cursor = nxt
+	}
+}
+
+// collectShareLinks 收集去重后的节点分享链接，包括订阅链接中抓取到的节点
+func collectShareLinks(rid string, req *SubsNodesReq) []string {
+	links := []string{}
+	seen := map[string]bool{}
+
+	add := func(link string) {
+		key, _, _ := strings.Cut(link, "#") // 同一节点只是备注不同也视为重复
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		links = append(links, link)
+	}
+
+	scanSubItems(rid, req, func(item *store.SubItem) {
+		for _, l := range item.Links {
+			if l.IsShareLink() {
+				add(l.Url)
+			}
+		}
+		if item.Status == store.StatusDead || item.Status == store.StatusExpired {
+			return // 订阅已失效，其中的节点大概率也不可用
+		}
+		for _, sub := range item.Subs { // 订阅链接中抓取到的节点
+			for _, link := range sub.Nodes {
+				add(link)
+			}
+		}
+	})
+
+	if req.needProbe() {
+		links = probeShareLinks(rid, req, links)
+	}
+	return links
+}
+
+// probeShareLinks 检测节点延迟，按参数过滤、排序；无法解析的链接会被丢弃
+func probeShareLinks(rid string, req *SubsNodesReq, links []string) []string {
+	ns := []*nodes.Node{}
+	parsed := []string{}
+	for _, link := range links {
+		if n, err := nodes.Parse(link); err == nil {
+			ns = append(ns, n)
+			parsed = append(parsed, link)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	results := nodeProber.ProbeAll(ctx, ns)
+
+	idx := []int{}
+	for i, r := range results {
+		if (req.reachable || req.maxDelay > 0) && !r.Ok {
+			continue
+		}
+		if req.maxDelay > 0 && r.Latency > req.maxDelay {
+			continue
+		}
+		idx = append(idx, i)
+	}
+	if req.sort == "latency" { // 不可连通的排在最后
+		slices.SortStableFunc(idx, func(a, b int) int {
+			ra, rb := results[a], results[b]
+			if ra.Ok != rb.Ok {
+				if ra.Ok {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(ra.Latency, rb.Latency)
+		})
+	}
+
+	res := make([]string, 0, len(idx))
+	for _, i := range idx {
+		res = append(res, parsed[i])
+	}
+	logs.Debug().Rid(rid).Int("links", len(links)).Int("probed", len(ns)).Int("kept", len(res)).Msg("probe nodes")
+	return res
+}
+
+// collectNodes 收集并解析节点，解析失败的忽略
+func collectNodes(rid string, req *SubsNodesReq) []*nodes.Node {
+	ns := []*nodes.Node{}
+	for _, link := range collectShareLinks(rid, req) {
+		n, err := nodes.Parse(link)
+		if err != nil {
+			logs.Trace().Rid(rid).Str("link", link).Err(err).Msg("parse node fail")
+			continue
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func replyText(w http.ResponseWriter, contentType string, body []byte) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(body)
+	return err
+}
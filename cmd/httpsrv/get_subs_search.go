@@ -0,0 +1,62 @@
+package httpsrv
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// GET /subs/search?q=机场 订阅&offset=0&number=10
+// 全文搜索，多个词用空格分隔，需同时命中；结果按时间倒序，命中的词在content中用<mark>标出，total为命中的消息总数
+// 分页方式同 /subs/list，响应中的offset < 0时表示已经查询完成；
+// 一次最多扫描一定数量的候选消息，因此某一页的结果可能少于number条，继续翻页即可
+func HndSubsSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rid := ulid.Make().String()
+
+	req := SubsListReq{
+		offset: 0,
+		number: 20,
+	}
+
+	q := r.URL.Query()
+	kw := strings.TrimSpace(q.Get("q"))
+	if offsetStr := q.Get("offset"); offsetStr != "" {
+		fmt.Sscanf(offsetStr, "%d", &req.offset)
+	}
+	if numberStr := q.Get("number"); numberStr != "" {
+		fmt.Sscanf(numberStr, "%d", &req.number)
+	}
+
+	resp := &SubsListResp{
+		Rtn:    0,
+		Msg:    "succ",
+		Offset: -1,
+	}
+	if kw == "" {
+		resp.Rtn, resp.Msg = -1, "q required"
+		replyJson(w, rid, resp)
+		return
+	}
+
+	nxt, items := store.SearchItems(rid, kw, req.offset, req.number)
+	for i := range items {
+		items[i].MsgContent = store.Highlight(items[i].MsgContent, kw)
+	}
+	resp.Offset = nxt
+	resp.Itmes = items
+	resp.Total = store.CountSearch(kw)
+
+	logs.Info().Rid(rid).Str("q", kw).Int64("offset", req.offset).Int64("number", req.number).
+		Int("hits", len(items)).Int64("total", resp.Total).Str(r.Method, r.URL.Path).Send()
+
+	replyJson(w, rid, resp)
+}
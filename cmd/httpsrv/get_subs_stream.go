@@ -0,0 +1,158 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"tgfreesub/cmd/hub"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	streamHeartbeat  = 15 * time.Second
+	streamBufSize    = 64
+	streamResumeMax  = 500                 // 断线重连时最多补发的消息数，超过时不补发，改为发送gap事件
+	streamResumeScan = streamResumeMax * 4 // 补发时最多扫描的消息数，超过时同样发送gap事件
+	streamRetryMilli = 3000
+)
+
+var itemHub *hub.Hub
+
+// SetItemHub 设置新消息的推送源，未设置时 /subs/stream 不可用
+func SetItemHub(h *hub.Hub) {
+	itemHub = h
+}
+
+// GET /subs/stream?channel=a,b
+// Server-Sent Events 推送新入库的消息，事件名为item，data为SubItem的json，id为消息的入库序号(seq)
+// 断线重连时浏览器会带上 Last-Event-ID，服务端补发之后入库的消息；也可用参数 last_event_id 指定；
+// 需要补发的消息超过streamResumeMax条时不补发，改为发送gap事件，客户端应重新拉取列表
+// 每15秒发送一次注释行作为心跳
+func HndSubsStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if itemHub == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	rid := ulid.Make().String()
+	q := r.URL.Query()
+	channels := splitParam(q.Get("channel"))
+	lastIdStr := r.Header.Get("Last-Event-ID")
+	if lastIdStr == "" {
+		lastIdStr = q.Get("last_event_id")
+	}
+	lastId, _ := strconv.ParseInt(lastIdStr, 10, 64)
+
+	match := func(item *store.SubItem) bool {
+		return len(channels) == 0 || slices.Contains(channels, strings.TrimPrefix(item.ChannelUrl, "t.me/"))
+	}
+
+	// 先订阅再查补发的消息，避免两者之间入库的消息丢失
+	sub := itemHub.Subscribe(streamBufSize, match)
+	defer itemHub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx 不缓冲
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMilli)
+	sent := map[string]bool{}
+	if lastId > 0 {
+		items, latest := resumeItems(rid, lastId, match)
+		if latest > 0 { // id 设为最新的序号，之后重连时不再重复gap
+			fmt.Fprintf(w, "id: %d\nevent: gap\ndata: {\"last_event_id\":%d}\n\n", latest, lastId)
+		}
+		for _, item := range items {
+			if err := writeItemEvent(w, &item); err != nil {
+				return
+			}
+			sent[item.Member()] = true
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logs.Warn(err).Rid(rid).Msg("stream flush fail")
+		return
+	}
+	logs.Info().Rid(rid).Strs("channels", channels).Int64("last_event_id", lastId).Int("resumed", len(sent)).
+		Str("from", r.RemoteAddr).Str(r.Method, r.URL.Path).Send()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			logs.Debug().Rid(rid).Int64("dropped", sub.Dropped()).Msg("stream closed")
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case item, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if sent[item.Member()] {
+				continue
+			}
+			if err := writeItemEvent(w, item); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// resumeItems 查询lastId之后入库的消息，按入库顺序返回；
+// 超过streamResumeMax条，或扫描了streamResumeScan条还没查完(频道过滤后命中很少)时不返回消息，latest为当前最新的入库序号
+func resumeItems(rid string, lastId int64, match func(*store.SubItem) bool) (res []store.SubItem, latest int64) {
+	var cursor int64
+	scanned := 0
+	for {
+		nxt, items := store.ItemsAfterSeq(rid, lastId, cursor, subsNodesPageSize)
+		if cursor == 0 && len(items) > 0 {
+			latest = items[0].Seq
+		}
+		scanned += len(items)
+		for i := range items {
+			if match(&items[i]) {
+				res = append(res, items[i])
+			}
+		}
+		if nxt < 0 || len(items) == 0 {
+			break
+		}
+		if len(res) > streamResumeMax || scanned >= streamResumeScan {
+			return nil, latest
+		}
+		cursor = nxt
+	}
+	if len(res) > streamResumeMax {
+		return nil, latest
+	}
+	slices.Reverse(res)
+	return res, 0
+}
+
+func writeItemEvent(w http.ResponseWriter, item *store.SubItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: item\ndata: %s\n\n", item.Seq, data)
+	return err
+}
@@ -0,0 +1,186 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"tgfreesub/cmd/extract"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	wsBufSize      = 64
+	wsWriteTimeout = 10 * time.Second // 超时说明客户端消费太慢，直接断开
+	wsPingInterval = 30 * time.Second
+	wsReadLimit    = 64 << 10
+)
+
+// WsFilter 连接的过滤条件，为空的条件不限制
+type WsFilter struct {
+	Channels []string `json:"channels,omitempty"` // 任一频道
+	Keywords []string `json:"keywords,omitempty"` // 需同时包含，不区分大小写
+	Has      []string `json:"has,omitempty"`      // 包含任一类型的链接
+}
+
+// WsMsg 双向的消息格式，按op区分：
+//
+//	客户端: subscribe(带filter，替换当前过滤条件) unsubscribe(暂停接收) ping
+//	服务端: subscribed item pong lagged(count为因消费太慢被丢弃的条数) error
+type WsMsg struct {
+	Op     string         `json:"op"`
+	Filter *WsFilter      `json:"filter,omitempty"`
+	Item   *store.SubItem `json:"item,omitempty"`
+	Count  int64          `json:"count,omitempty"`
+	Msg    string         `json:"msg,omitempty"`
+}
+
+func (f *WsFilter) normalize() {
+	for i, ch := range f.Channels {
+		f.Channels[i] = strings.TrimPrefix(ch, "t.me/")
+	}
+	for i, kw := range f.Keywords {
+		f.Keywords[i] = strings.ToLower(kw)
+	}
+}
+
+func (f *WsFilter) match(item *store.SubItem) bool {
+	if len(f.Channels) > 0 && !slices.Contains(f.Channels, strings.TrimPrefix(item.ChannelUrl, "t.me/")) {
+		return false
+	}
+	if len(f.Has) > 0 && !slices.ContainsFunc(item.Links, func(l extract.Link) bool { return slices.Contains(f.Has, string(l.Type)) }) {
+		return false
+	}
+	if len(f.Keywords) > 0 {
+		text := strings.ToLower(store.PlainText(item.MsgContent))
+		if slices.ContainsFunc(f.Keywords, func(kw string) bool { return !strings.Contains(text, kw) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// GET /ws?channel=a,b&q=机场&has=vmess,url
+// WebSocket 推送新入库的消息，消息格式见 WsMsg；url参数为初始的过滤条件，之后可以通过 subscribe 修改
+// 每个连接有独立的缓冲区，缓冲区满时丢弃消息并通过 lagged 通知；写超时则断开连接
+func HndWs(w http.ResponseWriter, r *http.Request) {
+	if itemHub == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	rid := ulid.Make().String()
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		logs.Warn(err).Rid(rid).Str("from", r.RemoteAddr).Msg("ws accept fail")
+		return
+	}
+	defer c.CloseNow()
+	c.SetReadLimit(wsReadLimit)
+
+	q := r.URL.Query()
+	filter := &WsFilter{
+		Channels: splitParam(q.Get("channel")),
+		Keywords: strings.Fields(q.Get("q")),
+		Has:      splitParam(q.Get("has")),
+	}
+	filter.normalize()
+
+	// 过滤条件由读协程修改，hub在发布时读取；nil 表示暂停接收
+	var cur atomic.Pointer[WsFilter]
+	cur.Store(filter)
+	sub := itemHub.Subscribe(wsBufSize, func(item *store.SubItem) bool {
+		f := cur.Load()
+		return f != nil && f.match(item)
+	})
+	defer itemHub.Unsubscribe(sub)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	logs.Info().Rid(rid).Str("from", r.RemoteAddr).Str(r.Method, r.URL.Path).Send()
+	write := func(msg *WsMsg) error {
+		wctx, wcancel := context.WithTimeout(ctx, wsWriteTimeout)
+		defer wcancel()
+		return wsjson.Write(wctx, c, msg)
+	}
+	if err := write(&WsMsg{Op: "subscribed", Filter: filter}); err != nil {
+		return
+	}
+
+	go func() {
+		defer cancel()
+		for {
+			msg := WsMsg{}
+			if err := wsjson.Read(ctx, c, &msg); err != nil {
+				if websocket.CloseStatus(err) == -1 && !errors.Is(err, context.Canceled) {
+					logs.Debug().Rid(rid).Err(err).Msg("ws read fail")
+				}
+				return
+			}
+
+			reply := &WsMsg{Op: msg.Op}
+			switch msg.Op {
+			case "subscribe":
+				f := msg.Filter
+				if f == nil {
+					f = &WsFilter{}
+				}
+				f.normalize()
+				cur.Store(f)
+				reply.Op, reply.Filter = "subscribed", f
+			case "unsubscribe":
+				cur.Store(nil)
+				reply.Op = "unsubscribed"
+			case "ping":
+				reply.Op = "pong"
+			default:
+				reply.Op, reply.Msg = "error", "unknown op: "+msg.Op
+			}
+			if err := write(reply); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	var lagged int64
+	for {
+		select {
+		case <-ctx.Done():
+			logs.Debug().Rid(rid).Int64("dropped", sub.Dropped()).Msg("ws closed")
+			return
+		case <-ticker.C:
+			pctx, pcancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := c.Ping(pctx)
+			pcancel()
+			if err != nil {
+				return
+			}
+		case item, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > lagged { // 先告诉客户端中间丢了消息
+				if err := write(&WsMsg{Op: "lagged", Count: dropped - lagged}); err != nil {
+					return
+				}
+				lagged = dropped
+			}
+			if err := write(&WsMsg{Op: "item", Item: item}); err != nil {
+				logs.Info().Rid(rid).Err(err).Msg("ws slow consumer, close")
+				c.Close(websocket.StatusPolicyViolation, "slow consumer")
+				return
+			}
+		}
+	}
+}
@@ -33,6 +33,25 @@ func StartHttpSrv(embeddedStaticFiles embed.FS, addr string) error {
 
 	// 单独处理API接口
 	http.HandleFunc("/subs/list", HndSubsList)
+	http.HandleFunc("/subs/nodes", HndSubsNodes)
+	http.HandleFunc("/subs/clash", HndSubsClash)
+	http.HandleFunc("/subs/singbox", HndSubsSingbox)
+	http.HandleFunc("/subs/history", HndSubsHistory)
+	http.HandleFunc("/subs/search", HndSubsSearch)
+	http.HandleFunc("/subs/feed.xml", HndSubsFeedRss)
+	http.HandleFunc("/subs/atom.xml", HndSubsFeedAtom)
+	http.HandleFunc("/subs/feed.json", HndSubsFeedJson)
+	http.HandleFunc("/subs/stream", HndSubsStream)
+	http.HandleFunc("/ws", HndWs)
+	http.HandleFunc("GET /channels/{name}/{file}", HndChannelFeed)
+
+	// 管理接口，需要 -admintoken
+	http.HandleFunc("GET /admin/channels", HndAdminChannelList)
+	http.HandleFunc("POST /admin/channels", HndAdminChannelAdd)
+	http.HandleFunc("POST /admin/channels/{name}/{op}", HndAdminChannelOp)
+	http.HandleFunc("DELETE /admin/channels/{name}", HndAdminChannelDel)
+	http.HandleFunc("GET /admin/discovery", HndAdminDiscoveryList)
+	http.HandleFunc("POST /admin/discovery/{key}/{op}", HndAdminDiscoveryOp)
 
 	logs.Info().Str("addr", addr).Msg("HTTP server running with embedded static files")
 
@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"sync"
+	"sync/atomic"
+	"tgfreesub/cmd/store"
+)
+
+// Hub 进程内的新消息发布/订阅，订阅者消费不及时时丢弃消息，不阻塞发布者
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*Sub]struct{}
+}
+
+// Sub 一个订阅者，从 C 读取新消息
+type Sub struct {
+	C       <-chan *store.SubItem
+	ch      chan *store.SubItem
+	filter  func(*store.SubItem) bool
+	dropped atomic.Int64
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[*Sub]struct{}{}}
+}
+
+// Subscribe 订阅新消息，filter 为空时接收全部；buf 为缓冲的消息数
+func (h *Hub) Subscribe(buf int, filter func(*store.SubItem) bool) *Sub {
+	ch := make(chan *store.SubItem, max(buf, 1))
+	s := &Sub{C: ch, ch: ch, filter: filter}
+
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Unsubscribe 取消订阅并关闭 C
+func (h *Hub) Unsubscribe(s *Sub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+// Publish 发布一条新入库的消息，item 的格式与查询结果一致
+func (h *Hub) Publish(item *store.SubItem) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs {
+		if s.filter != nil && !s.filter(item) {
+			continue
+		}
+		select {
+		case s.ch <- item:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped 因缓冲区满而丢弃的消息数
+func (s *Sub) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Count 当前订阅者数量
+func (h *Hub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
@@ -0,0 +1,346 @@
+package nodes
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Clash/Mihomo 配置中的 proxies 项
+type clashProxy struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              int               `yaml:"port"`
+	UUID              string            `yaml:"uuid,omitempty"`
+	AlterID           *int              `yaml:"alterId,omitempty"`
+	Cipher            string            `yaml:"cipher,omitempty"`
+	Password          string            `yaml:"password,omitempty"`
+	Flow              string            `yaml:"flow,omitempty"`
+	UDP               bool              `yaml:"udp,omitempty"`
+	TLS               bool              `yaml:"tls,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"` // vmess vless
+	SNI               string            `yaml:"sni,omitempty"`        // trojan hysteria2
+	SkipCertVerify    bool              `yaml:"skip-cert-verify,omitempty"`
+	ALPN              []string          `yaml:"alpn,omitempty"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	Network           string            `yaml:"network,omitempty"`
+	WSOpts            *clashWSOpts      `yaml:"ws-opts,omitempty"`
+	GrpcOpts          *clashGrpcOpts    `yaml:"grpc-opts,omitempty"`
+	HttpOpts          *clashHttpOpts    `yaml:"http-opts,omitempty"`
+	H2Opts            *clashH2Opts      `yaml:"h2-opts,omitempty"`
+	RealityOpts       *clashRealityOpts `yaml:"reality-opts,omitempty"`
+	Obfs              string            `yaml:"obfs,omitempty"`
+	ObfsPassword      string            `yaml:"obfs-password,omitempty"`
+	Plugin            string            `yaml:"plugin,omitempty"`
+	PluginOpts        map[string]any    `yaml:"plugin-opts,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type clashGrpcOpts struct {
+	ServiceName string `yaml:"grpc-service-name,omitempty"`
+}
+
+// tcp 的 http 头伪装
+type clashHttpOpts struct {
+	Method  string              `yaml:"method,omitempty"`
+	Path    []string            `yaml:"path,omitempty"`
+	Headers map[string][]string `yaml:"headers,omitempty"`
+}
+
+type clashH2Opts struct {
+	Host []string `yaml:"host,omitempty"`
+	Path string   `yaml:"path,omitempty"`
+}
+
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty"`
+}
+
+type clashGroup struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Proxies  []string `yaml:"proxies"`
+	Url      string   `yaml:"url,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+type clashConfig struct {
+	MixedPort   int          `yaml:"mixed-port"`
+	AllowLan    bool         `yaml:"allow-lan"`
+	Mode        string       `yaml:"mode"`
+	LogLevel    string       `yaml:"log-level"`
+	Proxies     []clashProxy `yaml:"proxies"`
+	ProxyGroups []clashGroup `yaml:"proxy-groups"`
+	Rules       []string     `yaml:"rules"`
+}
+
+const (
+	groupSelect   = "节点选择"
+	groupAuto     = "自动选择"
+	groupFallback = "故障转移"
+	probeUrl      = "http://www.gstatic.com/generate_204"
+)
+
+// ToClash 生成完整的 Clash/Mihomo 配置，不支持的节点会被忽略
+func ToClash(nodes []*Node) ([]byte, error) {
+	proxies := []clashProxy{}
+	for _, n := range uniqueNames(nodes, groupSelect, groupAuto, groupFallback, "DIRECT", "REJECT") {
+		if p, ok := n.clashProxy(); ok {
+			proxies = append(proxies, p)
+		}
+	}
+
+	names := []string{}
+	for _, p := range proxies {
+		names = append(names, p.Name)
+	}
+	if len(names) == 0 { // clash 不允许空的代理组
+		names = append(names, "DIRECT")
+	}
+
+	conf := clashConfig{
+		MixedPort: 7890,
+		Mode:      "rule",
+		LogLevel:  "info",
+		Proxies:   proxies,
+		ProxyGroups: []clashGroup{
+			{Name: groupSelect, Type: "select", Proxies: append([]string{groupAuto, groupFallback}, names...)},
+			{Name: groupAuto, Type: "url-test", Proxies: names, Url: probeUrl, Interval: 300},
+			{Name: groupFallback, Type: "fallback", Proxies: names, Url: probeUrl, Interval: 300},
+		},
+		Rules: []string{
+			"DOMAIN-SUFFIX,local,DIRECT",
+			"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+			"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+			"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+			"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+			"GEOIP,CN,DIRECT",
+			"MATCH," + groupSelect,
+		},
+	}
+
+	buf := bytes.Buffer{}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&conf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
+
+func (n *Node) clashProxy() (clashProxy, bool) {
+	p := clashProxy{
+		Name:   n.Name,
+		Type:   n.Type,
+		Server: n.Server,
+		Port:   n.Port,
+		UDP:    true,
+	}
+
+	switch n.Type {
+	case "vmess":
+		aid := n.AlterID
+		p.UUID, p.AlterID, p.Cipher = n.UUID, &aid, n.Cipher
+		p.TLS, p.ServerName = n.TLS, n.SNI
+	case "vless":
+		p.UUID, p.Flow = n.UUID, n.Flow
+		p.TLS, p.ServerName = n.TLS, n.SNI
+		if n.PublicKey != "" {
+			p.RealityOpts = &clashRealityOpts{PublicKey: n.PublicKey, ShortID: n.ShortID}
+		}
+	case "trojan":
+		p.Password, p.SNI = n.Password, n.SNI
+		if n.PublicKey != "" {
+			p.RealityOpts = &clashRealityOpts{PublicKey: n.PublicKey, ShortID: n.ShortID}
+		}
+	case "hysteria2":
+		p.Password, p.SNI = n.Password, n.SNI
+		p.Obfs, p.ObfsPassword = n.Obfs, n.ObfsPassword
+	case "ss":
+		p.Cipher, p.Password = n.Cipher, n.Password
+		if n.Plugin != "" && !n.clashSSPlugin(&p) {
+			return p, false
+		}
+	default:
+		return p, false
+	}
+
+	if n.TLS {
+		p.SkipCertVerify = n.Insecure
+		p.ALPN = n.ALPN
+		p.ClientFingerprint = n.Fingerprint
+	}
+
+	switch n.Network {
+	case "ws":
+		p.Network = "ws"
+		p.WSOpts = &clashWSOpts{Path: n.Path}
+		if n.Host != "" {
+			p.WSOpts.Headers = map[string]string{"Host": n.Host}
+		}
+	case "grpc":
+		p.Network = "grpc"
+		p.GrpcOpts = &clashGrpcOpts{ServiceName: n.ServiceName}
+	case "h2":
+		p.Network = "h2"
+		p.H2Opts = &clashH2Opts{Path: n.Path}
+		if n.Host != "" {
+			p.H2Opts.Host = []string{n.Host}
+		}
+	case "", "tcp":
+		if n.HeaderType == "http" {
+			p.Network = "http"
+			p.HttpOpts = &clashHttpOpts{Method: "GET", Path: []string{firstNonEmpty(n.Path, "/")}}
+			if n.Host != "" {
+				p.HttpOpts.Headers = map[string][]string{"Host": strings.Split(n.Host, ",")}
+			}
+		}
+	}
+	return p, true
+}
+
+// 只支持常见的 obfs 与 v2ray-plugin
+func (n *Node) clashSSPlugin(p *clashProxy) bool {
+	opts := n.PluginOpts
+	switch n.Plugin {
+	case "obfs-local", "simple-obfs", "obfs":
+		p.Plugin = "obfs"
+		p.PluginOpts = map[string]any{"mode": opts["obfs"], "host": opts["obfs-host"]}
+	case "v2ray-plugin":
+		p.Plugin = "v2ray-plugin"
+		_, tls := opts["tls"]
+		p.PluginOpts = map[string]any{"mode": "websocket", "tls": tls, "host": opts["host"], "path": opts["path"]}
+	default:
+		return false
+	}
+	return true
+}
+
+// 节点名(sing-box 的 tag)要求唯一，也不能与分组名重复，重名的追加序号
+func uniqueNames(nodes []*Node, reserved ...string) []*Node {
+	res := make([]*Node, 0, len(nodes))
+	used := map[string]bool{}
+	for _, name := range reserved {
+		used[name] = true
+	}
+	for _, n := range nodes {
+		cp := *n
+		for i := 2; used[cp.Name]; i++ {
+			cp.Name = n.Name + " " + strconv.Itoa(i)
+		}
+		used[cp.Name] = true
+		res = append(res, &cp)
+	}
+	return res
+}
+
+// FromClash 解析 Clash/Mihomo 配置中的 proxies，不支持的节点会被忽略
+func FromClash(data []byte) ([]*Node, error) {
+	var conf struct {
+		Proxies []clashProxy `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return nil, err
+	}
+
+	ns := []*Node{}
+	for _, p := range conf.Proxies {
+		if n, ok := p.node(); ok {
+			ns = append(ns, n)
+		}
+	}
+	return ns, nil
+}
+
+func (p *clashProxy) node() (*Node, bool) {
+	n := &Node{
+		Type:        p.Type,
+		Name:        p.Name,
+		Server:      p.Server,
+		Port:        p.Port,
+		UUID:        p.UUID,
+		Password:    p.Password,
+		Cipher:      p.Cipher,
+		Flow:        p.Flow,
+		TLS:         p.TLS,
+		SNI:         firstNonEmpty(p.ServerName, p.SNI),
+		Insecure:    p.SkipCertVerify,
+		ALPN:        p.ALPN,
+		Fingerprint: p.ClientFingerprint,
+		Network:     p.Network,
+	}
+	if p.AlterID != nil {
+		n.AlterID = *p.AlterID
+	}
+
+	switch p.Type {
+	case "vmess", "vless":
+	case "trojan", "hysteria2":
+		n.TLS = true
+		n.Obfs, n.ObfsPassword = p.Obfs, p.ObfsPassword
+	case "ss":
+		if p.Plugin != "" && !n.fromClashSSPlugin(p) {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	if p.RealityOpts != nil {
+		n.PublicKey, n.ShortID = p.RealityOpts.PublicKey, p.RealityOpts.ShortID
+	}
+	switch {
+	case p.WSOpts != nil:
+		n.Path = p.WSOpts.Path
+		n.Host = p.WSOpts.Headers["Host"]
+	case p.GrpcOpts != nil:
+		n.ServiceName = p.GrpcOpts.ServiceName
+	case p.H2Opts != nil:
+		n.Path = p.H2Opts.Path
+		if len(p.H2Opts.Host) > 0 {
+			n.Host = p.H2Opts.Host[0]
+		}
+	case p.HttpOpts != nil:
+		if len(p.HttpOpts.Path) > 0 {
+			n.Path = p.HttpOpts.Path[0]
+		}
+		n.Host = strings.Join(p.HttpOpts.Headers["Host"], ",")
+	}
+	n.normNetwork()
+
+	if n.Server == "" || n.Port <= 0 {
+		return nil, false
+	}
+	return n, true
+}
+
+func (n *Node) fromClashSSPlugin(p *clashProxy) bool {
+	str := func(k string) string {
+		if v, ok := p.PluginOpts[k].(string); ok {
+			return v
+		}
+		return ""
+	}
+	switch p.Plugin {
+	case "obfs":
+		n.Plugin = "obfs-local"
+		n.PluginOpts = map[string]string{"obfs": str("mode"), "obfs-host": str("host")}
+	case "v2ray-plugin":
+		n.Plugin = "v2ray-plugin"
+		n.PluginOpts = map[string]string{"mode": "websocket", "host": str("host"), "path": str("path")}
+		if tls, _ := p.PluginOpts["tls"].(bool); tls {
+			n.PluginOpts["tls"] = ""
+		}
+	default:
+		return false
+	}
+	return true
+}
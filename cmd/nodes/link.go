@@ -0,0 +1,148 @@
+package nodes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Link 将节点编码为分享链接，用于把 Clash/sing-box 订阅中的节点并入 v2ray 订阅
+func (n *Node) Link() string {
+	switch n.Type {
+	case "vmess":
+		return n.vmessLink()
+	case "vless":
+		u := n.urlLink(n.UUID)
+		q := n.urlQuery()
+		q.Set("encryption", "none")
+		if n.Flow != "" {
+			q.Set("flow", n.Flow)
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	case "trojan":
+		u := n.urlLink(n.Password)
+		q := n.urlQuery()
+		if n.PublicKey == "" { // trojan 总是tls，只需要标出reality
+			q.Del("security")
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	case "hysteria2":
+		u := n.urlLink(n.Password)
+		q := url.Values{}
+		if n.SNI != "" {
+			q.Set("sni", n.SNI)
+		}
+		if n.Insecure {
+			q.Set("insecure", "1")
+		}
+		if n.Obfs != "" {
+			q.Set("obfs", n.Obfs)
+			q.Set("obfs-password", n.ObfsPassword)
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	case "ss":
+		return n.ssLink()
+	}
+	return ""
+}
+
+func (n *Node) vmessLink() string {
+	v := map[string]string{
+		"v":    "2",
+		"ps":   n.Name,
+		"add":  n.Server,
+		"port": strconv.Itoa(n.Port),
+		"id":   n.UUID,
+		"aid":  strconv.Itoa(n.AlterID),
+		"scy":  n.Cipher,
+		"net":  firstNonEmpty(n.Network, "tcp"),
+		"type": firstNonEmpty(n.HeaderType, "none"),
+		"host": n.Host,
+		"path": n.Path,
+		"sni":  n.SNI,
+		"fp":   n.Fingerprint,
+		"alpn": strings.Join(n.ALPN, ","),
+	}
+	if n.Network == "grpc" {
+		v["path"] = n.ServiceName
+	}
+	if n.TLS {
+		v["tls"] = "tls"
+	}
+	data, _ := json.Marshal(v)
+	return "vmess://" + base64.StdEncoding.EncodeToString(data)
+}
+
+func (n *Node) ssLink() string {
+	userinfo := base64.RawURLEncoding.EncodeToString([]byte(n.Cipher + ":" + n.Password))
+	link := "ss://" + userinfo + "@" + net.JoinHostPort(n.Server, strconv.Itoa(n.Port))
+	if n.Plugin != "" {
+		opts := []string{n.Plugin}
+		for _, k := range slices.Sorted(maps.Keys(n.PluginOpts)) {
+			opts = append(opts, k+"="+n.PluginOpts[k])
+		}
+		link += "/?plugin=" + url.QueryEscape(strings.Join(opts, ";"))
+	}
+	return link + "#" + url.PathEscape(n.Name)
+}
+
+func (n *Node) urlLink(user string) *url.URL {
+	return &url.URL{
+		Scheme:   n.Type,
+		User:     url.User(user),
+		Host:     net.JoinHostPort(n.Server, strconv.Itoa(n.Port)),
+		Fragment: n.Name,
+	}
+}
+
+// vless/trojan 共用的 url 参数
+func (n *Node) urlQuery() url.Values {
+	q := url.Values{}
+	switch {
+	case n.PublicKey != "":
+		q.Set("security", "reality")
+		q.Set("pbk", n.PublicKey)
+		if n.ShortID != "" {
+			q.Set("sid", n.ShortID)
+		}
+	case n.TLS:
+		q.Set("security", "tls")
+	default:
+		q.Set("security", "none")
+	}
+	if n.SNI != "" {
+		q.Set("sni", n.SNI)
+	}
+	if n.Fingerprint != "" {
+		q.Set("fp", n.Fingerprint)
+	}
+	if n.Insecure {
+		q.Set("allowInsecure", "1")
+	}
+	if len(n.ALPN) > 0 {
+		q.Set("alpn", strings.Join(n.ALPN, ","))
+	}
+
+	q.Set("type", firstNonEmpty(n.Network, "tcp"))
+	if n.HeaderType != "" {
+		q.Set("headerType", n.HeaderType)
+	}
+	if n.Host != "" {
+		q.Set("host", n.Host)
+	}
+	if n.Path != "" {
+		q.Set("path", n.Path)
+	}
+	if n.ServiceName != "" {
+		q.Set("serviceName", n.ServiceName)
+	}
+	return q
+}
@@ -0,0 +1,356 @@
+package nodes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNodeUnsupport = errors.New("node type unsupport")
+	ErrNodeInvalid   = errors.New("node invalid")
+)
+
+// Node 解析后的节点，字段为各协议的并集，未用到的字段为空
+type Node struct {
+	Type     string `json:"type"` // vmess vless ss trojan hysteria2
+	Name     string `json:"name,omitempty"`
+	Server   string `json:"server"`
+	Port     int    `json:"port"`
+	UUID     string `json:"uuid,omitempty"`     // vmess vless
+	AlterID  int    `json:"alter_id,omitempty"` // vmess
+	Password string `json:"password,omitempty"` // ss trojan hysteria2
+	Cipher   string `json:"cipher,omitempty"`   // ss vmess
+	Flow     string `json:"flow,omitempty"`     // vless
+
+	Network     string `json:"network,omitempty"`     // ws grpc h2，为空表示tcp
+	HeaderType  string `json:"header_type,omitempty"` // tcp 的伪装类型，http 为 http 头伪装
+	Path        string `json:"path,omitempty"`
+	Host        string `json:"host,omitempty"`
+	ServiceName string `json:"service_name,omitempty"` // grpc
+
+	TLS         bool     `json:"tls,omitempty"`
+	SNI         string   `json:"sni,omitempty"`
+	Insecure    bool     `json:"insecure,omitempty"`
+	ALPN        []string `json:"alpn,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	PublicKey   string   `json:"public_key,omitempty"` // reality
+	ShortID     string   `json:"short_id,omitempty"`   // reality
+
+	Obfs         string `json:"obfs,omitempty"` // hysteria2
+	ObfsPassword string `json:"obfs_password,omitempty"`
+
+	Plugin     string            `json:"plugin,omitempty"` // ss
+	PluginOpts map[string]string `json:"plugin_opts,omitempty"`
+}
+
+// Addr 节点的 server:port
+func (n *Node) Addr() string {
+	return net.JoinHostPort(n.Server, strconv.Itoa(n.Port))
+}
+
+// Parse 解析节点分享链接
+func Parse(link string) (*Node, error) {
+	scheme, _, ok := strings.Cut(link, "://")
+	if !ok {
+		return nil, ErrNodeInvalid
+	}
+
+	var node *Node
+	var err error
+	switch strings.ToLower(scheme) {
+	case "vmess":
+		node, err = parseVmess(link)
+	case "vless":
+		node, err = parseVless(link)
+	case "ss":
+		node, err = parseSS(link)
+	case "trojan":
+		node, err = parseTrojan(link)
+	case "hysteria2", "hy2":
+		node, err = parseHysteria2(link)
+	default:
+		return nil, ErrNodeUnsupport
+	}
+	if err != nil {
+		return nil, err
+	}
+	if node.Server == "" || node.Port <= 0 || node.Port > 65535 {
+		return nil, ErrNodeInvalid
+	}
+	if node.Name == "" {
+		node.Name = node.Addr()
+	}
+	return node, nil
+}
+
+// vmess://base64({"v":"2","ps":"name","add":"host","port":"443","id":"uuid",...})
+func parseVmess(link string) (*Node, error) {
+	data, err := decodeBase64(link[len("vmess://"):])
+	if err != nil {
+		return nil, err
+	}
+
+	var v struct {
+		Ps   string          `json:"ps"`
+		Add  string          `json:"add"`
+		Port json.RawMessage `json:"port"` // 有的是字符串有的是数字
+		Id   string          `json:"id"`
+		Aid  json.RawMessage `json:"aid"`
+		Scy  string          `json:"scy"`
+		Net  string          `json:"net"`
+		Type string          `json:"type"` // tcp 的伪装类型
+		Host string          `json:"host"`
+		Path string          `json:"path"`
+		Tls  string          `json:"tls"`
+		Sni  string          `json:"sni"`
+		Alpn string          `json:"alpn"`
+		Fp   string          `json:"fp"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		Type:        "vmess",
+		Name:        v.Ps,
+		Server:      v.Add,
+		Port:        jsonInt(v.Port),
+		UUID:        v.Id,
+		AlterID:     jsonInt(v.Aid),
+		Cipher:      v.Scy,
+		Network:     v.Net,
+		HeaderType:  v.Type,
+		Host:        v.Host,
+		Path:        v.Path,
+		TLS:         v.Tls == "tls",
+		SNI:         v.Sni,
+		Fingerprint: v.Fp,
+	}
+	if node.Cipher == "" {
+		node.Cipher = "auto"
+	}
+	if node.Network == "grpc" {
+		node.ServiceName, node.Path = node.Path, ""
+	}
+	node.normNetwork()
+	if v.Alpn != "" {
+		node.ALPN = strings.Split(v.Alpn, ",")
+	}
+	return node, nil
+}
+
+// vless://uuid@host:port?security=reality&sni=&fp=&pbk=&sid=&type=ws&path=&host=&flow=#name
+func parseVless(link string) (*Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{
+		Type: "vless",
+		UUID: u.User.Username(),
+		Flow: u.Query().Get("flow"),
+	}
+	if err := fillUrlNode(node, u); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// trojan://password@host:port?sni=&type=ws&path=&allowInsecure=1#name
+func parseTrojan(link string) (*Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{
+		Type:     "trojan",
+		Password: u.User.Username(),
+		TLS:      true,
+	}
+	if err := fillUrlNode(node, u); err != nil {
+		return nil, err
+	}
+	node.TLS = true // trojan 总是tls
+	return node, nil
+}
+
+// hysteria2://auth@host:port?sni=&insecure=1&obfs=salamander&obfs-password=#name
+func parseHysteria2(link string) (*Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{Type: "hysteria2"}
+	if pass, ok := u.User.Password(); ok { // 少数链接把认证拆成了 user:pass
+		node.Password = u.User.Username() + ":" + pass
+	} else {
+		node.Password = u.User.Username()
+	}
+	if err := fillUrlNode(node, u); err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	node.Obfs = q.Get("obfs")
+	node.ObfsPassword = q.Get("obfs-password")
+	node.TLS = true
+	node.Network = ""
+	return node, nil
+}
+
+// ss://base64(method:password)@host:port?plugin=...#name
+// ss://base64(method:password@host:port)#name
+func parseSS(link string) (*Node, error) {
+	body, name, _ := strings.Cut(link[len("ss://"):], "#")
+	body, query, _ := strings.Cut(body, "?")
+	body = strings.TrimSuffix(body, "/")
+
+	if !strings.Contains(body, "@") { // 整体base64
+		data, err := decodeBase64(body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(data)
+	}
+
+	userinfo, hostport, ok := cutLast(body, "@")
+	if !ok {
+		return nil, ErrNodeInvalid
+	}
+	if !strings.Contains(userinfo, ":") { // SIP002: userinfo 单独base64
+		if data, err := decodeBase64(userinfo); err == nil {
+			userinfo = string(data)
+		} else if dec, err := url.PathUnescape(userinfo); err == nil {
+			userinfo = dec
+		}
+	}
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok {
+		return nil, ErrNodeInvalid
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		Type:     "ss",
+		Server:   host,
+		Password: password,
+		Cipher:   method,
+	}
+	node.Port, _ = strconv.Atoi(port)
+	node.Name, _ = url.PathUnescape(name)
+
+	if query != "" {
+		q, _ := url.ParseQuery(query)
+		if plugin := q.Get("plugin"); plugin != "" {
+			parseSSPlugin(node, plugin)
+		}
+	}
+	return node, nil
+}
+
+// plugin=obfs-local;obfs=http;obfs-host=www.bing.com
+func parseSSPlugin(node *Node, plugin string) {
+	parts := strings.Split(plugin, ";")
+	node.Plugin = parts[0]
+	node.PluginOpts = map[string]string{}
+	for _, p := range parts[1:] {
+		if p == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(p, "=")
+		node.PluginOpts[k] = v
+	}
+}
+
+// vless/trojan/hysteria2 共用的 url 参数
+func fillUrlNode(node *Node, u *url.URL) error {
+	node.Server = u.Hostname()
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return ErrNodeInvalid
+	}
+	node.Port = port
+	node.Name = u.Fragment
+
+	q := u.Query()
+	switch q.Get("security") {
+	case "tls", "xtls":
+		node.TLS = true
+	case "reality":
+		node.TLS = true
+		node.PublicKey = q.Get("pbk")
+		node.ShortID = q.Get("sid")
+	}
+	node.SNI = firstNonEmpty(q.Get("sni"), q.Get("peer"))
+	node.Fingerprint = q.Get("fp")
+	node.Insecure = q.Get("allowInsecure") == "1" || q.Get("insecure") == "1"
+	if alpn := q.Get("alpn"); alpn != "" {
+		node.ALPN = strings.Split(alpn, ",")
+	}
+
+	node.Network = q.Get("type")
+	node.Host = q.Get("host")
+	node.Path = q.Get("path")
+	node.ServiceName = q.Get("serviceName")
+	node.HeaderType = q.Get("headerType")
+	node.normNetwork()
+	return nil
+}
+
+// normNetwork 统一传输方式的写法：tcp 记为空；net=http 是 tcp 的 http 头伪装(不是h2)，记为 HeaderType=http
+func (n *Node) normNetwork() {
+	if n.Network == "http" {
+		n.Network, n.HeaderType = "", "http"
+	}
+	if n.Network == "tcp" {
+		n.Network = ""
+	}
+	if n.HeaderType == "none" || n.Network != "" {
+		n.HeaderType = ""
+	}
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "=")
+	if data, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return data, nil
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func jsonInt(raw json.RawMessage) int {
+	s := strings.Trim(string(raw), `"`)
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return "", s, false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (n *Node) String() string {
+	return fmt.Sprintf("%s://%s(%s)", n.Type, n.Addr(), n.Name)
+}
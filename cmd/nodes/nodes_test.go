@@ -0,0 +1,160 @@
+package nodes
+
+import (
+	"encoding/base64"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func vmessLink(js string) string {
+	return "vmess://" + base64.StdEncoding.EncodeToString([]byte(js))
+}
+
+var testLinks = map[string]string{
+	"vmess-ws-tls": vmessLink(`{"ps":"vmess-ws-tls","add":"a.example.com","port":"443","id":"11111111-2222-3333-4444-555555555555","aid":"0","scy":"auto","net":"ws","host":"cdn.example.com","path":"/ws","tls":"tls","sni":"a.example.com"}`),
+	"vmess-http":   vmessLink(`{"ps":"vmess-http","add":"b.example.com","port":80,"id":"11111111-2222-3333-4444-555555555555","aid":0,"net":"tcp","type":"http","host":"www.example.com","path":"/"}`),
+	"vless-reality": "vless://11111111-2222-3333-4444-555555555555@c.example.com:443?security=reality&sni=www.microsoft.com&fp=chrome" +
+		"&pbk=pubkey&sid=ab12&type=grpc&serviceName=svc&flow=#vless-reality",
+	"trojan-ws":      "trojan://pass@d.example.com:443?sni=d.example.com&type=ws&path=%2Ftj&host=d.example.com#trojan-ws",
+	"trojan-reality": "trojan://pass@e.example.com:443?security=reality&sni=www.apple.com&pbk=pubkey&sid=cd34&fp=chrome#trojan-reality",
+	"ss":             "ss://YWVzLTI1Ni1nY206cGFzcw@f.example.com:8388#ss",
+	"ss-obfs":        "ss://YWVzLTI1Ni1nY206cGFzcw@g.example.com:8388/?plugin=" + "obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dwww.example.com" + "#ss-obfs",
+	"hysteria2":      "hysteria2://auth@h.example.com:8443?sni=h.example.com&insecure=1&obfs=salamander&obfs-password=op#hysteria2",
+}
+
+func parseTestNodes(t *testing.T) []*Node {
+	t.Helper()
+	ns := []*Node{}
+	for _, name := range slices.Sorted(maps.Keys(testLinks)) {
+		n, err := Parse(testLinks[name])
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name string
+		want Node
+	}{
+		{"vmess-ws-tls", Node{Type: "vmess", Name: "vmess-ws-tls", Server: "a.example.com", Port: 443, UUID: "11111111-2222-3333-4444-555555555555",
+			Cipher: "auto", Network: "ws", Host: "cdn.example.com", Path: "/ws", TLS: true, SNI: "a.example.com"}},
+		{"vmess-http", Node{Type: "vmess", Name: "vmess-http", Server: "b.example.com", Port: 80, UUID: "11111111-2222-3333-4444-555555555555",
+			Cipher: "auto", HeaderType: "http", Host: "www.example.com", Path: "/"}},
+		{"vless-reality", Node{Type: "vless", Name: "vless-reality", Server: "c.example.com", Port: 443, UUID: "11111111-2222-3333-4444-555555555555",
+			Network: "grpc", ServiceName: "svc", TLS: true, SNI: "www.microsoft.com", Fingerprint: "chrome", PublicKey: "pubkey", ShortID: "ab12"}},
+		{"trojan-reality", Node{Type: "trojan", Name: "trojan-reality", Server: "e.example.com", Port: 443, Password: "pass",
+			TLS: true, SNI: "www.apple.com", Fingerprint: "chrome", PublicKey: "pubkey", ShortID: "cd34"}},
+		{"ss-obfs", Node{Type: "ss", Name: "ss-obfs", Server: "g.example.com", Port: 8388, Cipher: "aes-256-gcm", Password: "pass",
+			Plugin: "obfs-local", PluginOpts: map[string]string{"obfs": "http", "obfs-host": "www.example.com"}}},
+		{"hysteria2", Node{Type: "hysteria2", Name: "hysteria2", Server: "h.example.com", Port: 8443, Password: "auth",
+			TLS: true, SNI: "h.example.com", Insecure: true, Obfs: "salamander", ObfsPassword: "op"}},
+	}
+	for _, c := range cases {
+		n, err := Parse(testLinks[c.name])
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(*n, c.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", c.name, *n, c.want)
+		}
+	}
+
+	for _, link := range []string{"", "vmess", "socks://a.example.com:1080", "vmess://not-base64!"} {
+		if _, err := Parse(link); err == nil {
+			t.Errorf("%q: want error", link)
+		}
+	}
+}
+
+func TestLinkRoundTrip(t *testing.T) {
+	for _, n := range parseTestNodes(t) {
+		got, err := Parse(n.Link())
+		if err != nil {
+			t.Fatalf("%s: %v", n.Name, err)
+		}
+		if !reflect.DeepEqual(got, n) {
+			t.Errorf("%s: %s\n got %+v\nwant %+v", n.Name, n.Link(), *got, *n)
+		}
+	}
+}
+
+func TestClashRoundTrip(t *testing.T) {
+	ns := parseTestNodes(t)
+	data, err := ToClash(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromClash(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(ns) {
+		t.Fatalf("nodes = %d, want %d\n%s", len(got), len(ns), data)
+	}
+	for i, n := range ns {
+		if !reflect.DeepEqual(got[i], n) {
+			t.Errorf("%s:\n got %+v\nwant %+v", n.Name, *got[i], *n)
+		}
+	}
+}
+
+func TestSingBoxRoundTrip(t *testing.T) {
+	ns := parseTestNodes(t)
+	data, err := ToSingBox(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromSingBox(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sing-box 不支持 tcp 的 http 头伪装
+	want := slices.DeleteFunc(slices.Clone(ns), func(n *Node) bool { return n.HeaderType == "http" })
+	if len(got) != len(want) {
+		t.Fatalf("nodes = %d, want %d\n%s", len(got), len(want), data)
+	}
+	for i, n := range want {
+		if !reflect.DeepEqual(got[i], n) {
+			t.Errorf("%s:\n got %+v\nwant %+v", n.Name, *got[i], *n)
+		}
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	ns := []*Node{
+		{Type: "ss", Name: groupSelect, Server: "a.example.com", Port: 1, Cipher: "aes-256-gcm", Password: "p"},
+		{Type: "ss", Name: sbTagAuto, Server: "b.example.com", Port: 1, Cipher: "aes-256-gcm", Password: "p"},
+		{Type: "ss", Name: sbTagAuto, Server: "c.example.com", Port: 1, Cipher: "aes-256-gcm", Password: "p"},
+	}
+
+	data, _ := ToClash(ns)
+	got, _ := FromClash(data)
+	names := []string{}
+	for _, n := range got {
+		names = append(names, n.Name)
+	}
+	if want := []string{groupSelect + " 2", sbTagAuto, sbTagAuto + " 2"}; !slices.Equal(names, want) {
+		t.Errorf("clash names = %q, want %q", names, want)
+	}
+
+	data, _ = ToSingBox(ns)
+	got, _ = FromSingBox(data)
+	names = names[:0]
+	for _, n := range got {
+		names = append(names, n.Name)
+	}
+	if want := []string{groupSelect, sbTagAuto + " 2", sbTagAuto + " 3"}; !slices.Equal(names, want) {
+		t.Errorf("sing-box tags = %q, want %q", names, want)
+	}
+	if strings.Count(string(data), `"tag": "auto"`) != 1 {
+		t.Errorf("duplicated auto tag:\n%s", data)
+	}
+}
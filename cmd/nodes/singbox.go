@@ -0,0 +1,278 @@
+package nodes
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// sing-box 配置中的 outbounds 项
+type sbOutbound struct {
+	Type       string       `json:"type"`
+	Tag        string       `json:"tag"`
+	Server     string       `json:"server,omitempty"`
+	ServerPort int          `json:"server_port,omitempty"`
+	UUID       string       `json:"uuid,omitempty"`
+	Security   string       `json:"security,omitempty"` // vmess
+	AlterID    int          `json:"alter_id,omitempty"`
+	Flow       string       `json:"flow,omitempty"`
+	Method     string       `json:"method,omitempty"` // shadowsocks
+	Password   string       `json:"password,omitempty"`
+	Plugin     string       `json:"plugin,omitempty"`
+	PluginOpts string       `json:"plugin_opts,omitempty"`
+	Obfs       *sbObfs      `json:"obfs,omitempty"`
+	TLS        *sbTLS       `json:"tls,omitempty"`
+	Transport  *sbTransport `json:"transport,omitempty"`
+
+	// selector / urltest
+	Outbounds []string `json:"outbounds,omitempty"`
+	Default   string   `json:"default,omitempty"`
+	Url       string   `json:"url,omitempty"`
+	Interval  string   `json:"interval,omitempty"`
+}
+
+type sbObfs struct {
+	Type     string `json:"type"`
+	Password string `json:"password,omitempty"`
+}
+
+type sbTLS struct {
+	Enabled    bool       `json:"enabled"`
+	ServerName string     `json:"server_name,omitempty"`
+	Insecure   bool       `json:"insecure,omitempty"`
+	ALPN       []string   `json:"alpn,omitempty"`
+	UTLS       *sbUTLS    `json:"utls,omitempty"`
+	Reality    *sbReality `json:"reality,omitempty"`
+}
+
+type sbUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type sbReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+type sbTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Host        []string          `json:"host,omitempty"` // http
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"` // grpc
+}
+
+type sbConfig struct {
+	Log       map[string]any `json:"log"`
+	Outbounds []sbOutbound   `json:"outbounds"`
+	Route     map[string]any `json:"route"`
+}
+
+const (
+	sbTagSelect = "select"
+	sbTagAuto   = "auto"
+	sbTagDirect = "direct"
+)
+
+// ToSingBox 生成 sing-box 配置，包含节点 outbounds 以及 selector/urltest 分组
+func ToSingBox(nodes []*Node) ([]byte, error) {
+	outbounds := []sbOutbound{}
+	for _, n := range uniqueNames(nodes, sbTagSelect, sbTagAuto, sbTagDirect) {
+		if ob, ok := n.singBoxOutbound(); ok {
+			outbounds = append(outbounds, ob)
+		}
+	}
+
+	tags := []string{}
+	for _, ob := range outbounds {
+		tags = append(tags, ob.Tag)
+	}
+
+	groups := []sbOutbound{
+		{Type: "selector", Tag: sbTagSelect, Outbounds: append([]string{sbTagAuto}, tags...), Default: sbTagAuto},
+		{Type: "urltest", Tag: sbTagAuto, Outbounds: tags, Url: probeUrl, Interval: "5m"},
+	}
+	if len(tags) == 0 { // 分组不能为空
+		groups[0].Outbounds = []string{sbTagDirect}
+		groups[0].Default = sbTagDirect
+		groups = groups[:1]
+	}
+
+	conf := sbConfig{
+		Log:       map[string]any{"level": "info"},
+		Outbounds: append(append(groups, outbounds...), sbOutbound{Type: "direct", Tag: sbTagDirect}),
+		Route: map[string]any{
+			"auto_detect_interface": true,
+			"final":                 sbTagSelect,
+		},
+	}
+	return json.MarshalIndent(&conf, "", "  ")
+}
+
+func (n *Node) singBoxOutbound() (sbOutbound, bool) {
+	ob := sbOutbound{
+		Type:       n.Type,
+		Tag:        n.Name,
+		Server:     n.Server,
+		ServerPort: n.Port,
+	}
+
+	switch n.Type {
+	case "vmess":
+		ob.UUID, ob.Security, ob.AlterID = n.UUID, n.Cipher, n.AlterID
+	case "vless":
+		ob.UUID, ob.Flow = n.UUID, n.Flow
+	case "trojan":
+		ob.Password = n.Password
+	case "hysteria2":
+		ob.Password = n.Password
+		if n.Obfs != "" {
+			ob.Obfs = &sbObfs{Type: n.Obfs, Password: n.ObfsPassword}
+		}
+	case "ss":
+		ob.Type = "shadowsocks"
+		ob.Method, ob.Password = n.Cipher, n.Password
+		if n.Plugin != "" && !n.singBoxSSPlugin(&ob) {
+			return ob, false
+		}
+	default:
+		return ob, false
+	}
+
+	if n.TLS {
+		ob.TLS = &sbTLS{
+			Enabled:    true,
+			ServerName: n.SNI,
+			Insecure:   n.Insecure,
+			ALPN:       n.ALPN,
+		}
+		if n.Fingerprint != "" {
+			ob.TLS.UTLS = &sbUTLS{Enabled: true, Fingerprint: n.Fingerprint}
+		}
+		if n.PublicKey != "" {
+			ob.TLS.Reality = &sbReality{Enabled: true, PublicKey: n.PublicKey, ShortID: n.ShortID}
+		}
+	}
+
+	switch n.Network {
+	case "ws":
+		ob.Transport = &sbTransport{Type: "ws", Path: n.Path}
+		if n.Host != "" {
+			ob.Transport.Headers = map[string]string{"Host": n.Host}
+		}
+	case "grpc":
+		ob.Transport = &sbTransport{Type: "grpc", ServiceName: n.ServiceName}
+	case "h2":
+		ob.Transport = &sbTransport{Type: "http", Path: n.Path}
+		if n.Host != "" {
+			ob.Transport.Host = []string{n.Host}
+		}
+	case "", "tcp":
+		if n.HeaderType == "http" { // sing-box 不支持 tcp 的 http 头伪装
+			return ob, false
+		}
+	}
+	return ob, true
+}
+
+// sing-box 的插件参数沿用 SIP003 的 k=v;k=v 格式
+func (n *Node) singBoxSSPlugin(ob *sbOutbound) bool {
+	switch n.Plugin {
+	case "obfs-local", "simple-obfs", "obfs":
+		ob.Plugin = "obfs-local"
+	case "v2ray-plugin":
+		ob.Plugin = "v2ray-plugin"
+	default:
+		return false
+	}
+	opts := []string{}
+	for _, k := range slices.Sorted(maps.Keys(n.PluginOpts)) {
+		v := n.PluginOpts[k]
+		if v == "" {
+			opts = append(opts, k)
+			continue
+		}
+		opts = append(opts, k+"="+v)
+	}
+	ob.PluginOpts = strings.Join(opts, ";")
+	return true
+}
+
+// FromSingBox 解析 sing-box 配置中的 outbounds，分组与不支持的类型会被忽略
+func FromSingBox(data []byte) ([]*Node, error) {
+	var conf struct {
+		Outbounds []sbOutbound `json:"outbounds"`
+	}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, err
+	}
+
+	ns := []*Node{}
+	for _, ob := range conf.Outbounds {
+		if n, ok := ob.node(); ok {
+			ns = append(ns, n)
+		}
+	}
+	return ns, nil
+}
+
+func (ob *sbOutbound) node() (*Node, bool) {
+	n := &Node{
+		Type:     ob.Type,
+		Name:     ob.Tag,
+		Server:   ob.Server,
+		Port:     ob.ServerPort,
+		UUID:     ob.UUID,
+		AlterID:  ob.AlterID,
+		Cipher:   ob.Security,
+		Flow:     ob.Flow,
+		Password: ob.Password,
+	}
+
+	switch ob.Type {
+	case "vmess", "vless", "trojan":
+	case "hysteria2":
+		if ob.Obfs != nil {
+			n.Obfs, n.ObfsPassword = ob.Obfs.Type, ob.Obfs.Password
+		}
+	case "shadowsocks":
+		n.Type, n.Cipher = "ss", ob.Method
+		if ob.Plugin != "" {
+			parseSSPlugin(n, ob.Plugin+";"+ob.PluginOpts)
+		}
+	default:
+		return nil, false
+	}
+
+	if tls := ob.TLS; tls != nil && tls.Enabled {
+		n.TLS, n.SNI, n.Insecure, n.ALPN = true, tls.ServerName, tls.Insecure, tls.ALPN
+		if tls.UTLS != nil {
+			n.Fingerprint = tls.UTLS.Fingerprint
+		}
+		if tls.Reality != nil && tls.Reality.Enabled {
+			n.PublicKey, n.ShortID = tls.Reality.PublicKey, tls.Reality.ShortID
+		}
+	}
+
+	if tr := ob.Transport; tr != nil {
+		switch tr.Type {
+		case "ws":
+			n.Network, n.Path, n.Host = "ws", tr.Path, tr.Headers["Host"]
+		case "grpc":
+			n.Network, n.ServiceName = "grpc", tr.ServiceName
+		case "http":
+			n.Network, n.Path = "h2", tr.Path
+			if len(tr.Host) > 0 {
+				n.Host = tr.Host[0]
+			}
+		}
+	}
+
+	if n.Server == "" || n.Port <= 0 {
+		return nil, false
+	}
+	return n, true
+}
@@ -0,0 +1,295 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"tgfreesub/cmd/filter"
+	"tgfreesub/cmd/hub"
+	"tgfreesub/cmd/store"
+	"tgfreesub/internal/logs"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	maxAttempts    = 10               // 超过后放弃
+	retryBaseDelay = 10 * time.Second // 第n次失败后等待 base<<(n-1)
+	retryMaxDelay  = time.Hour
+	retryInterval  = 5 * time.Second // 扫描重试队列的间隔
+	retryBatch     = 50
+	jobQueueSize   = 256
+)
+
+var ErrPermanent = errors.New("webhook permanent fail")
+
+// Webhook 一个推送目标，Rules 为空时推送全部消息
+type Webhook struct {
+	Name   string        `json:"name"`
+	Url    string        `json:"url"`
+	Secret string        `json:"secret,omitempty"` // 非空时对请求签名
+	Rules  *filter.Rules `json:"rules,omitempty"`  // 格式同 -rules 文件
+}
+
+// Payload 推送的请求体
+type Payload struct {
+	Event string         `json:"event"`
+	Item  *store.SubItem `json:"item"`
+}
+
+// Load 从json文件加载webhook列表
+func Load(path string) ([]*Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	hooks := []*Webhook{}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i, h := range hooks {
+		if h.Url == "" {
+			return nil, fmt.Errorf("webhook %d: empty url", i)
+		}
+		if h.Name == "" {
+			h.Name = h.Url
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("webhook %s: duplicate name", h.Name)
+		}
+		names[h.Name] = true
+		if h.Rules != nil {
+			if err := h.Rules.Compile(); err != nil {
+				return nil, fmt.Errorf("webhook %s: %w", h.Name, err)
+			}
+		}
+	}
+	return hooks, nil
+}
+
+// Sign 计算签名：hex(HMAC-SHA256(secret, timestamp + "." + body))
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type job struct {
+	hook *Webhook
+	d    store.WebhookDelivery
+}
+
+// Notifier 把新入库的消息推送到配置的webhook，失败的请求保存在存储中按指数退避重试
+type Notifier struct {
+	hooks  map[string]*Webhook
+	order  []*Webhook
+	client *http.Client
+	jobs   chan job
+}
+
+func NewNotifier(hooks []*Webhook) *Notifier {
+	n := &Notifier{
+		hooks:  map[string]*Webhook{},
+		order:  hooks,
+		client: &http.Client{Timeout: 15 * time.Second},
+		jobs:   make(chan job, jobQueueSize),
+	}
+	for _, h := range hooks {
+		n.hooks[h.Name] = h
+	}
+	return n
+}
+
+func (n *Notifier) WithClient(c *http.Client) *Notifier {
+	n.client = c
+	return n
+}
+
+// Start 订阅hub中的新消息，启动workers个推送协程及重试协程
+func (n *Notifier) Start(ctx context.Context, h *hub.Hub, workers int) {
+	for range max(workers, 1) {
+		go n.worker(ctx)
+	}
+	go n.retryLoop(ctx)
+
+	sub := h.Subscribe(jobQueueSize, nil)
+	go func() {
+		defer h.Unsubscribe(sub)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				n.Notify(item)
+			}
+		}
+	}()
+}
+
+// Notify 按各webhook的过滤规则生成推送任务；任务队列满时直接放入重试队列
+func (n *Notifier) Notify(item *store.SubItem) {
+	rid := ulid.Make().String()
+	body, err := json.Marshal(&Payload{Event: "item", Item: item})
+	if err != nil {
+		logs.Warn(err).Rid(rid).Msg("marshal payload fail")
+		return
+	}
+
+	// 过滤规则按入库时的格式匹配：频道名不带前缀，内容为纯文本
+	raw := *item
+	raw.ChannelUrl = strings.TrimPrefix(raw.ChannelUrl, "t.me/")
+	raw.MsgContent = store.PlainText(raw.MsgContent)
+
+	now := time.Now()
+	for _, h := range n.order {
+		if h.Rules != nil {
+			if keep, reason := h.Rules.Match(&raw); !keep {
+				logs.Debug().Rid(rid).Str("hook", h.Name).Str("member", item.Member()).Str("reason", reason).Msg("webhook skip")
+				continue
+			}
+		}
+		j := job{hook: h, d: store.WebhookDelivery{
+			ID:        ulid.Make().String(),
+			Hook:      h.Name,
+			Body:      body,
+			NextAt:    now.Unix(),
+			CreatedAt: now.Unix(),
+		}}
+		select {
+		case n.jobs <- j:
+		default:
+			if err := store.SaveDelivery(&j.d); err != nil {
+				logs.Warn(err).Rid(rid).Str("hook", h.Name).Msg("save delivery fail")
+			}
+		}
+	}
+}
+
+func (n *Notifier) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-n.jobs:
+			n.deliver(ctx, j.hook, &j.d, false)
+		}
+	}
+}
+
+// retryLoop 定期处理重试队列中到期的请求
+func (n *Notifier) retryLoop(ctx context.Context) {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n.RetryDue(ctx)
+	}
+}
+
+// RetryDue 重试所有到期的请求
+func (n *Notifier) RetryDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		ds := store.DueDeliveries(time.Now().Unix(), retryBatch)
+		for i := range ds {
+			d := &ds[i]
+			h, ok := n.hooks[d.Hook]
+			if !ok { // 配置中已删除
+				logs.Info().Str("id", d.ID).Str("hook", d.Hook).Msg("webhook removed, drop delivery")
+				store.DelDelivery(d.ID)
+				continue
+			}
+			n.deliver(ctx, h, d, true)
+		}
+		if len(ds) < retryBatch {
+			return
+		}
+	}
+}
+
+// deliver 发送一次请求；失败时更新重试时间保存到存储，queued 表示请求已在重试队列中
+func (n *Notifier) deliver(ctx context.Context, h *Webhook, d *store.WebhookDelivery, queued bool) {
+	d.Attempts++
+	err := n.post(ctx, h, d)
+	if err == nil {
+		logs.Debug().Str("id", d.ID).Str("hook", h.Name).Int("attempts", d.Attempts).Msg("webhook succ")
+		if queued {
+			store.DelDelivery(d.ID)
+		}
+		return
+	}
+
+	if errors.Is(err, ErrPermanent) || d.Attempts >= maxAttempts {
+		logs.Warn(err).Str("id", d.ID).Str("hook", h.Name).Int("attempts", d.Attempts).Msg("webhook fail, give up")
+		if queued {
+			store.DelDelivery(d.ID)
+		}
+		return
+	}
+
+	d.LastError = err.Error()
+	d.NextAt = time.Now().Add(retryDelay(d.Attempts)).Unix()
+	logs.Info().Str("id", d.ID).Str("hook", h.Name).Int("attempts", d.Attempts).Str("err", d.LastError).Msg("webhook fail, retry later")
+	if err := store.SaveDelivery(d); err != nil {
+		logs.Warn(err).Str("id", d.ID).Str("hook", h.Name).Msg("save delivery fail")
+	}
+}
+
+func retryDelay(attempts int) time.Duration {
+	if attempts > 10 {
+		return retryMaxDelay
+	}
+	return min(retryBaseDelay<<(attempts-1), retryMaxDelay)
+}
+
+func (n *Notifier) post(ctx context.Context, h *Webhook, d *store.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Url, bytes.NewReader(d.Body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tgfreesub-webhook")
+	req.Header.Set("X-Tgfreesub-Event", "item")
+	req.Header.Set("X-Tgfreesub-Delivery", d.ID)
+	req.Header.Set("X-Tgfreesub-Timestamp", ts)
+	if h.Secret != "" {
+		req.Header.Set("X-Tgfreesub-Signature", "sha256="+Sign(h.Secret, ts, d.Body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return fmt.Errorf("http status %d", code)
+	default: // 其他4xx重试也不会成功
+		return fmt.Errorf("%w: http status %d", ErrPermanent, code)
+	}
+}
@@ -0,0 +1,213 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"tgfreesub/cmd/filter"
+	"tgfreesub/cmd/hub"
+	"tgfreesub/cmd/store"
+	"time"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver 本地webhook接收端，按顺序返回 codes 中的状态码，用完后返回200
+type receiver struct {
+	*httptest.Server
+	mu    sync.Mutex
+	codes []int
+	reqs  []received
+	got   chan received
+}
+
+func newReceiver(t *testing.T, codes ...int) *receiver {
+	rv := &receiver{codes: codes, got: make(chan received, 16)}
+	rv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec := received{header: r.Header.Clone(), body: body}
+
+		rv.mu.Lock()
+		code := http.StatusOK
+		if len(rv.codes) > 0 {
+			code, rv.codes = rv.codes[0], rv.codes[1:]
+		}
+		rv.reqs = append(rv.reqs, rec)
+		rv.mu.Unlock()
+
+		w.WriteHeader(code)
+		rv.got <- rec
+	}))
+	t.Cleanup(rv.Close)
+	return rv
+}
+
+func (rv *receiver) count() int {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	return len(rv.reqs)
+}
+
+func initStore(t *testing.T) {
+	if err := store.StoreInit("bolt://" + filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.StoreClose() })
+}
+
+func testItem(channel, content string) *store.SubItem {
+	return &store.SubItem{ChannelUrl: "t.me/" + channel, ChannelName: channel, Msgid: 1, PubDate: time.Now().Unix(), MsgContent: content}
+}
+
+func wait(t *testing.T, rv *receiver) received {
+	t.Helper()
+	select {
+	case rec := <-rv.got:
+		return rec
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not received")
+		return received{}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	initStore(t)
+	rv := newReceiver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub()
+	NewNotifier([]*Webhook{{Name: "a", Url: rv.URL, Secret: "s3cret"}}).Start(ctx, h, 1)
+	time.Sleep(50 * time.Millisecond) // 等待订阅hub
+	h.Publish(testItem("ch", "免费节点"))
+
+	rec := wait(t, rv)
+	ts := rec.header.Get("X-Tgfreesub-Timestamp")
+	want := "sha256=" + Sign("s3cret", ts, rec.body)
+	if ts == "" || rec.header.Get("X-Tgfreesub-Signature") != want {
+		t.Errorf("signature = %q, want %q", rec.header.Get("X-Tgfreesub-Signature"), want)
+	}
+	if rec.header.Get("X-Tgfreesub-Event") != "item" || rec.header.Get("X-Tgfreesub-Delivery") == "" {
+		t.Errorf("headers = %v", rec.header)
+	}
+
+	p := Payload{}
+	if err := json.Unmarshal(rec.body, &p); err != nil || p.Event != "item" || p.Item.ChannelName != "ch" {
+		t.Errorf("payload = %s, err = %v", rec.body, err)
+	}
+}
+
+func TestNoSecretNoSignature(t *testing.T) {
+	initStore(t)
+	rv := newReceiver(t)
+	n := NewNotifier([]*Webhook{{Name: "a", Url: rv.URL}})
+
+	n.Notify(testItem("ch", "免费节点"))
+	j := <-n.jobs
+	n.deliver(context.Background(), j.hook, &j.d, false)
+	if rec := wait(t, rv); rec.header.Get("X-Tgfreesub-Signature") != "" {
+		t.Errorf("unexpected signature: %v", rec.header)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	initStore(t)
+	rv := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	n := NewNotifier([]*Webhook{{Name: "a", Url: rv.URL}})
+	ctx := context.Background()
+
+	n.Notify(testItem("ch", "免费节点"))
+	j := <-n.jobs
+	start := time.Now()
+	n.deliver(ctx, j.hook, &j.d, false)
+
+	// 失败后保存到重试队列，未到期时不会重试
+	if ds := store.DueDeliveries(time.Now().Unix(), 10); len(ds) != 0 {
+		t.Fatalf("not due yet: %+v", ds)
+	}
+	ds := store.DueDeliveries(time.Now().Add(time.Hour).Unix(), 10)
+	if len(ds) != 1 {
+		t.Fatalf("saved deliveries = %d, want 1", len(ds))
+	}
+	d := ds[0]
+	if d.Attempts != 1 || d.LastError == "" || d.NextAt < start.Add(retryBaseDelay).Unix() {
+		t.Errorf("delivery = %+v", d)
+	}
+
+	// 到期后重试，再次失败时退避时间翻倍
+	d.NextAt = time.Now().Unix()
+	store.SaveDelivery(&d)
+	n.RetryDue(ctx)
+	ds = store.DueDeliveries(time.Now().Add(time.Hour).Unix(), 10)
+	if len(ds) != 1 || ds[0].Attempts != 2 || ds[0].NextAt < time.Now().Add(2*retryBaseDelay).Unix()-1 {
+		t.Fatalf("after 2nd attempt: %+v", ds)
+	}
+
+	// 成功后从重试队列删除
+	ds[0].NextAt = time.Now().Unix()
+	store.SaveDelivery(&ds[0])
+	n.RetryDue(ctx)
+	if ds := store.DueDeliveries(time.Now().Add(time.Hour).Unix(), 10); len(ds) != 0 {
+		t.Errorf("delivered but still queued: %+v", ds)
+	}
+	if c := rv.count(); c != 3 {
+		t.Errorf("requests = %d, want 3", c)
+	}
+}
+
+func TestPermanentFail(t *testing.T) {
+	initStore(t)
+	rv := newReceiver(t, http.StatusBadRequest)
+	n := NewNotifier([]*Webhook{{Name: "a", Url: rv.URL}})
+
+	n.Notify(testItem("ch", "免费节点"))
+	j := <-n.jobs
+	n.deliver(context.Background(), j.hook, &j.d, false)
+	if ds := store.DueDeliveries(time.Now().Add(time.Hour).Unix(), 10); len(ds) != 0 {
+		t.Errorf("4xx should not be retried: %+v", ds)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 10: time.Hour, 20: time.Hour}
+	for attempts, want := range cases {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	initStore(t)
+	all, clash := newReceiver(t), newReceiver(t)
+
+	rules := &filter.Rules{Rule: filter.Rule{Include: []string{"clash"}}}
+	if err := rules.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	n := NewNotifier([]*Webhook{{Name: "all", Url: all.URL}, {Name: "clash", Url: clash.URL, Rules: rules}})
+
+	n.Notify(testItem("ch", "<b>Clash</b> 订阅"))
+	n.Notify(testItem("ch", "v2ray 订阅"))
+	close(n.jobs)
+	for j := range n.jobs {
+		n.deliver(context.Background(), j.hook, &j.d, false)
+	}
+	if c := all.count(); c != 2 {
+		t.Errorf("all: requests = %d, want 2", c)
+	}
+	if c := clash.count(); c != 1 {
+		t.Errorf("clash: requests = %d, want 1", c)
+	} else if !strings.Contains(string(clash.reqs[0].body), "Clash") {
+		t.Errorf("clash: body = %s", clash.reqs[0].body)
+	}
+}
@@ -0,0 +1,174 @@
+package prober
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"tgfreesub/cmd/nodes"
+	"tgfreesub/internal/netguard"
+	"time"
+)
+
+const maxCacheSize = 8192
+
+var ErrProbeUnsupport = errors.New("probe unsupport") // 基于udp的协议无法用tcp检测
+
+// Result 一个节点的检测结果
+type Result struct {
+	Addr      string `json:"addr"`
+	Ok        bool   `json:"ok"`
+	Latency   int64  `json:"latency,omitempty"` // 毫秒，tcp建连+tls握手
+	Error     string `json:"error,omitempty"`
+	CheckedAt int64  `json:"checked_at"`
+}
+
+// Prober 对节点的 server:port 做tcp建连(tls类协议再做一次握手)，测量延迟
+// 结果按 addr+sni 缓存 ttl 时长，避免每次请求都重新检测；
+// 节点地址来自频道消息，不可信，内网、回环等地址不会连接，结果为不可连通
+type Prober struct {
+	Timeout     time.Duration
+	Concurrency int
+	TTL         time.Duration
+
+	dialer *net.Dialer
+	mu     sync.Mutex
+	cache  map[string]Result
+}
+
+func NewProber(concurrency int, timeout time.Duration) *Prober {
+	return &Prober{
+		Timeout:     timeout,
+		Concurrency: max(concurrency, 1),
+		TTL:         10 * time.Minute,
+		dialer:      &net.Dialer{Control: netguard.Control},
+		cache:       map[string]Result{},
+	}
+}
+
+// WithTTL 设置结果缓存时长，0表示不缓存
+func (p *Prober) WithTTL(ttl time.Duration) *Prober {
+	p.TTL = ttl
+	return p
+}
+
+// ProbeAll 并发检测，返回结果与 ns 一一对应
+func (p *Prober) ProbeAll(ctx context.Context, ns []*nodes.Node) []Result {
+	res := make([]Result, len(ns))
+	sem := make(chan struct{}, p.Concurrency)
+	wg := sync.WaitGroup{}
+	for i, n := range ns {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			res[i] = p.Probe(ctx, n)
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// Probe 检测单个节点
+func (p *Prober) Probe(ctx context.Context, n *nodes.Node) Result {
+	key := n.Addr() + "/" + serverName(n)
+	if r, ok := p.cached(key); ok {
+		return r
+	}
+
+	r := Result{Addr: n.Addr(), CheckedAt: time.Now().Unix()}
+	latency, err := p.probe(ctx, n)
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Ok, r.Latency = true, latency.Milliseconds()
+	}
+
+	if ctx.Err() == nil { // 请求取消导致的失败不缓存
+		p.mu.Lock()
+		if len(p.cache) >= maxCacheSize {
+			p.purgeLocked()
+		}
+		p.cache[key] = r
+		p.mu.Unlock()
+	}
+	return r
+}
+
+// purgeLocked 清理过期的结果，仍然超过上限时淘汰最早检测的结果
+func (p *Prober) purgeLocked() {
+	for k, r := range p.cache {
+		if time.Since(time.Unix(r.CheckedAt, 0)) >= p.TTL {
+			delete(p.cache, k)
+		}
+	}
+	if len(p.cache) < maxCacheSize {
+		return
+	}
+
+	keys := slices.SortedFunc(maps.Keys(p.cache), func(a, b string) int {
+		return cmp.Compare(p.cache[a].CheckedAt, p.cache[b].CheckedAt)
+	})
+	for _, k := range keys[:len(keys)-maxCacheSize*3/4] { // 多淘汰一些，避免每次写入都要排序
+		delete(p.cache, k)
+	}
+}
+
+func (p *Prober) cached(key string) (Result, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.cache[key]
+	if !ok {
+		return r, false
+	}
+	if time.Since(time.Unix(r.CheckedAt, 0)) >= p.TTL {
+		delete(p.cache, key)
+		return r, false
+	}
+	return r, true
+}
+
+func (p *Prober) probe(ctx context.Context, n *nodes.Node) (time.Duration, error) {
+	if n.Type == "hysteria2" {
+		return 0, ErrProbeUnsupport
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := p.dialer.DialContext(ctx, "tcp", n.Addr())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if n.TLS {
+		// 只关心能否握手，不校验证书；reality 节点会转发到伪装站点完成握手
+		tc := tls.Client(conn, &tls.Config{
+			ServerName:         serverName(n),
+			InsecureSkipVerify: true,
+			NextProtos:         n.ALPN,
+		})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+func serverName(n *nodes.Node) string {
+	if !n.TLS {
+		return ""
+	}
+	for _, s := range []string{n.SNI, n.Host} {
+		if s != "" {
+			return s
+		}
+	}
+	return n.Server
+}
@@ -0,0 +1,192 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"tgfreesub/cmd/nodes"
+	"tgfreesub/internal/netguard"
+	"time"
+)
+
+// testProber 放开回环地址，测试使用本机的监听端口
+func testProber(concurrency int, timeout time.Duration) *Prober {
+	p := NewProber(concurrency, timeout)
+	p.dialer.Control = func(network, address string, c syscall.RawConn) error {
+		if ap, err := netip.ParseAddrPort(address); err == nil && ap.Addr().IsLoopback() {
+			return nil
+		}
+		return netguard.Control(network, address, c)
+	}
+	return p
+}
+
+func listenNode(t *testing.T) (*nodes.Node, net.Listener) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return addrNode(t, "ss", ln.Addr().String()), ln
+}
+
+func addrNode(t *testing.T, typ, addr string) *nodes.Node {
+	host, port, _ := net.SplitHostPort(addr)
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &nodes.Node{Type: typ, Server: host, Port: p}
+}
+
+func TestProbeTCP(t *testing.T) {
+	n, _ := listenNode(t)
+	p := testProber(4, time.Second)
+
+	r := p.Probe(context.Background(), n)
+	if !r.Ok || r.Error != "" || r.Addr != n.Addr() || r.CheckedAt == 0 {
+		t.Fatalf("probe = %+v", r)
+	}
+
+	closed, ln := listenNode(t)
+	ln.Close()
+	if r := p.Probe(context.Background(), closed); r.Ok || r.Error == "" {
+		t.Errorf("closed port: %+v", r)
+	}
+}
+
+func TestProbeForbidden(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			c.Close()
+		}
+	}()
+
+	p := NewProber(4, time.Second)
+	ns := []*nodes.Node{
+		addrNode(t, "ss", ln.Addr().String()),
+		{Type: "trojan", Server: "10.0.0.1", Port: 443, TLS: true},
+		{Type: "vmess", Server: "169.254.169.254", Port: 80},
+		{Type: "vless", Server: "100.64.0.1", Port: 443},
+	}
+	for i, r := range p.ProbeAll(context.Background(), ns) {
+		if r.Ok || !strings.Contains(r.Error, netguard.ErrForbiddenAddr.Error()) {
+			t.Errorf("%s: %+v", ns[i].Addr(), r)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := accepted.Load(); n != 0 {
+		t.Errorf("loopback dialed %d times", n)
+	}
+}
+
+func TestProbeTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	p := testProber(4, time.Second)
+
+	n := addrNode(t, "trojan", srv.Listener.Addr().String())
+	n.TLS, n.SNI = true, "example.com"
+	if r := p.Probe(context.Background(), n); !r.Ok {
+		t.Fatalf("tls probe = %+v", r)
+	}
+
+	// 普通tcp端口上握手失败
+	plain, _ := listenNode(t)
+	plain.Type, plain.TLS = "trojan", true
+	if r := p.Probe(context.Background(), plain); r.Ok {
+		t.Errorf("tls on plain tcp: %+v", r)
+	}
+}
+
+func TestProbeUnsupport(t *testing.T) {
+	n, _ := listenNode(t)
+	n.Type = "hysteria2"
+	r := testProber(1, time.Second).Probe(context.Background(), n)
+	if r.Ok || r.Error != ErrProbeUnsupport.Error() {
+		t.Errorf("hysteria2: %+v", r)
+	}
+}
+
+func TestProbeCacheTTL(t *testing.T) {
+	n, ln := listenNode(t)
+	p := testProber(1, time.Second)
+
+	if r := p.Probe(context.Background(), n); !r.Ok {
+		t.Fatalf("probe = %+v", r)
+	}
+	ln.Close()
+	if r := p.Probe(context.Background(), n); !r.Ok {
+		t.Errorf("cached result expected: %+v", r)
+	}
+
+	// 过期后重新检测
+	p.WithTTL(0)
+	if r := p.Probe(context.Background(), n); r.Ok {
+		t.Errorf("expired cache should re-probe: %+v", r)
+	}
+}
+
+func TestProbeCanceledNotCached(t *testing.T) {
+	n, _ := listenNode(t)
+	p := testProber(1, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := p.Probe(ctx, n); r.Ok {
+		t.Fatalf("canceled probe = %+v", r)
+	}
+	if _, ok := p.cached(n.Addr() + "/"); ok {
+		t.Error("canceled probe should not be cached")
+	}
+}
+
+func TestProbeCacheSize(t *testing.T) {
+	p := testProber(1, time.Second)
+	now := time.Now().Unix()
+	for i := range maxCacheSize {
+		p.cache["k"+strconv.Itoa(i)] = Result{Ok: true, CheckedAt: now - int64(maxCacheSize-i)}
+	}
+
+	n, _ := listenNode(t)
+	p.Probe(context.Background(), n)
+	if len(p.cache) > maxCacheSize {
+		t.Fatalf("cache size = %d, max %d", len(p.cache), maxCacheSize)
+	}
+	if _, ok := p.cache["k0"]; ok {
+		t.Error("oldest entry should be evicted")
+	}
+	if _, ok := p.cache["k"+strconv.Itoa(maxCacheSize-1)]; !ok {
+		t.Error("newest entry should be kept")
+	}
+	if _, ok := p.cached(n.Addr() + "/"); !ok {
+		t.Error("new result should be cached")
+	}
+}
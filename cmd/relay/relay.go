@@ -0,0 +1,221 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"tgfreesub/cmd/hub"
+	"tgfreesub/cmd/store"
+	"tgfreesub/cmd/tg"
+	"tgfreesub/internal/logs"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tgerr"
+)
+
+const (
+	ModeForward = "forward" // 直接转发，保留"转发自"
+	ModeRepost  = "repost"  // 整理格式后重新发送
+
+	maxMsgLen      = 4096 // tg单条消息的最大长度，按 UTF-16 code unit 计算
+	maxFloodRetry  = 3
+	relayQueueSize = 1024
+)
+
+var ErrRelayNoTarget = errors.New("relay target not resolved")
+
+var reBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// Sender 发送消息的接口，由 tg.TgSuber 实现
+type Sender interface {
+	Channel(chanid int64) (tg.SubChannelInfo, bool)
+	ResolveChannel(ctx context.Context, name string) (tg.SubChannelInfo, error)
+	ForwardMsg(ctx context.Context, to, from *tg.SubChannelInfo, msgid int) (int, error)
+	SendMsg(ctx context.Context, to *tg.SubChannelInfo, text string) (int, error)
+}
+
+// Relayer 把新入库的消息转发到自己的频道/群组，按固定间隔限速，转发记录保存在存储中避免重复
+type Relayer struct {
+	sender   Sender
+	name     string // 目标频道名
+	mode     string
+	interval time.Duration // 两次发送的最小间隔
+
+	target *tg.SubChannelInfo
+	last   time.Time
+}
+
+func NewRelayer(s Sender, target, mode string) *Relayer {
+	if mode != ModeRepost {
+		mode = ModeForward
+	}
+	return &Relayer{
+		sender:   s,
+		name:     target,
+		mode:     mode,
+		interval: 3 * time.Second,
+	}
+}
+
+func (r *Relayer) WithInterval(d time.Duration) *Relayer {
+	r.interval = d
+	return r
+}
+
+// Run 解析目标频道后订阅hub中的新消息并逐条转发，直到ctx取消
+func (r *Relayer) Run(ctx context.Context, h *hub.Hub) error {
+	target, err := r.sender.ResolveChannel(ctx, r.name)
+	if err != nil {
+		logs.Warn(err).Str("target", r.name).Msg("resolve relay target fail")
+		return err
+	}
+	r.target = &target
+	logs.Info().Str("target", r.name).Int64("id", target.ChannelID).Str("mode", r.mode).Msg("relay start")
+
+	sub := h.Subscribe(relayQueueSize, nil)
+	defer h.Unsubscribe(sub)
+
+	var dropped int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case item, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			if n := sub.Dropped(); n > dropped {
+				logs.Warn(nil).Int64("dropped", n-dropped).Msg("relay queue full, items dropped")
+				dropped = n
+			}
+			r.Relay(ctx, item)
+		}
+	}
+}
+
+// Relay 转发一条消息，已转发过的消息直接跳过
+func (r *Relayer) Relay(ctx context.Context, item *store.SubItem) error {
+	if r.target == nil {
+		return ErrRelayNoTarget
+	}
+	member := item.Member()
+	if item.ChannelID == r.target.ChannelID { // 不转发自己频道的消息
+		return nil
+	}
+	if store.GetRelayed(r.target.ChannelID, member) != 0 {
+		logs.Debug().Str("member", member).Msg("already relayed")
+		return nil
+	}
+
+	msgid, err := r.send(ctx, item)
+	if err != nil {
+		logs.Warn(err).Str("member", member).Str("target", r.name).Msg("relay fail")
+		return err
+	}
+	logs.Debug().Str("member", member).Str("target", r.name).Int("relayed", msgid).Msg("relay succ")
+
+	if msgid == 0 {
+		msgid = -1 // 已发送但没拿到msgid
+	}
+	if err := store.SetRelayed(r.target.ChannelID, member, msgid); err != nil {
+		logs.Warn(err).Str("member", member).Msg("SetRelayed fail")
+	}
+	return nil
+}
+
+func (r *Relayer) send(ctx context.Context, item *store.SubItem) (int, error) {
+	forward := r.mode == ModeForward
+	from, ok := r.sender.Channel(item.ChannelID)
+	if forward && !ok {
+		forward = false // 不是当前订阅的频道，没有access hash，只能重新发送
+	}
+
+	for i := 0; ; i++ {
+		if err := r.wait(ctx); err != nil {
+			return 0, err
+		}
+
+		var msgid int
+		var err error
+		if forward {
+			msgid, err = r.sender.ForwardMsg(ctx, r.target, &from, int(item.Msgid))
+		} else {
+			msgid, err = r.sender.SendMsg(ctx, r.target, RepostText(item))
+		}
+		if err == nil {
+			return msgid, nil
+		}
+
+		if forward && tgerr.Is(err, "CHAT_FORWARDS_RESTRICTED") {
+			logs.Info().Str("member", item.Member()).Msg("forward restricted, repost instead")
+			forward = false
+			continue
+		}
+		d, ok := tgerr.AsFloodWait(err)
+		if !ok || i >= maxFloodRetry {
+			return 0, err
+		}
+		logs.Info().Str("target", r.name).Dur("wait", d).Msg("relay flood wait")
+		r.last = time.Now().Add(d)
+	}
+}
+
+// wait 限速：与上次发送至少间隔 interval
+func (r *Relayer) wait(ctx context.Context) error {
+	if d := time.Until(r.last.Add(r.interval)); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	r.last = time.Now()
+	return nil
+}
+
+// RepostText 重新发送时的消息文本：纯文本内容，补充正文中没有的链接，末尾附上来源
+func RepostText(item *store.SubItem) string {
+	text := strings.TrimSpace(store.PlainText(item.MsgContent))
+	text = reBlankLines.ReplaceAllString(text, "\n\n")
+
+	var extra []string
+	for _, l := range item.Links {
+		if !strings.Contains(text, l.Url) {
+			extra = append(extra, l.Url)
+		}
+	}
+
+	chname := strings.TrimPrefix(item.ChannelUrl, "t.me/")
+	tail := "\n\n来源：" + item.ChannelName + " https://t.me/" + chname + "/" + strconv.FormatInt(item.Msgid, 10)
+	if len(extra) > 0 {
+		tail = "\n\n" + strings.Join(extra, "\n") + tail
+	}
+
+	if n := maxMsgLen - utf16Len(tail); utf16Len(text) > n {
+		text = clipUtf16(text, n-1) + "…"
+	}
+	return text + tail
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// clipUtf16 截取前n个 UTF-16 code unit，不拆开代理对
+func clipUtf16(s string, n int) string {
+	for i, r := range s {
+		if n -= utf16.RuneLen(r); n < 0 {
+			return s[:i]
+		}
+	}
+	return s
+}
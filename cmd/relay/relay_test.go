@@ -0,0 +1,28 @@
+package relay
+
+import (
+	"strings"
+	"testing"
+	"tgfreesub/cmd/store"
+)
+
+func TestRepostTextLimit(t *testing.T) {
+	for _, body := range []string{strings.Repeat("a", 5000), strings.Repeat("😀", 3000), strings.Repeat("中😀", 2000)} {
+		item := &store.SubItem{ChannelUrl: "t.me/test", ChannelName: "测试", Msgid: 1, MsgContent: body}
+		text := RepostText(item)
+		if n := utf16Len(text); n > maxMsgLen {
+			t.Errorf("utf16 len = %d, want <= %d", n, maxMsgLen)
+		}
+		if !strings.HasSuffix(text, "https://t.me/test/1") || !strings.Contains(text, "…") {
+			t.Errorf("text not clipped: %q", text[len(text)-64:])
+		}
+		if strings.ContainsRune(text, '�') {
+			t.Errorf("surrogate pair split")
+		}
+	}
+
+	item := &store.SubItem{ChannelUrl: "t.me/test", ChannelName: "测试", Msgid: 1, MsgContent: strings.Repeat("😀", 100)}
+	if text := RepostText(item); strings.Contains(text, "…") {
+		t.Errorf("short text clipped: %q", text)
+	}
+}
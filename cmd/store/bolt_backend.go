@@ -0,0 +1,550 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"tgfreesub/internal/logs"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bbolt 没有zset，用两个bucket模拟：
+//
+//	z:<name>  key=score(8字节大端)+member  value=nil   按score有序
+//	zm:<name> key=member                   value=score 用于判重/取score
+//	zn        key=<name>                   value=成员数(8字节大端)，避免每次统计都遍历bucket
+//
+// hash 直接用 bucket h:<name>，key为member，value为json
+const (
+	boltZsetPrefix   = "z:"
+	boltZsetMPrefix  = "zm:"
+	boltZsetCount    = "zn"
+	boltHashPrefix   = "h:"
+	boltSubsIndex    = "subs_index"
+	boltSubsItemHash = "subs_item"
+	boltChannelPts   = "channel_pts"
+	boltRelayPrefix  = "relay_"
+	boltCheckRecord  = "check_record"
+	boltFingerprint  = "fingerprint"
+	boltWebhookRetry = "webhook_retry"
+	boltChannels     = "channels"
+	boltDiscovery    = "discovery"
+)
+
+var errBoltKeyNotFound = errors.New("bolt key not found")
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+func newBoltBackend(path string) (*boltBackend, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltBackend{db: db}, nil
+}
+
+func (bb *boltBackend) Close() error {
+	return bb.db.Close()
+}
+
+func (bb *boltBackend) AddItem(rid string, item *SubItem) error {
+	score := item.calcScore()
+	member := item.Member()
+
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		if _, ok := boltZsetScore(tx, boltSubsIndex, member); ok {
+			logs.Trace().Rid(rid).Str("member", member).Msg("had recored")
+			return ErrItemExisted
+		}
+
+		if err := boltHashSet(tx, boltSubsItemHash, member, item); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Msg("boltHashSet fail")
+			return err
+		}
+
+		logs.Info().Rid(rid).Str("member", member).Int64("score", score).Msg("add new record")
+		return boltZsetAdd(tx, boltSubsIndex, score, member)
+	})
+}
+
+func (bb *boltBackend) GetItemsTotal(_ string) int64 {
+	var total int64
+	bb.db.View(func(tx *bolt.Tx) error {
+		total = boltZsetCard(tx, boltSubsIndex)
+		return nil
+	})
+	return total
+}
+
+func (bb *boltBackend) QuerySubItems(rid string, cursor, number int64) (int64, []SubItem) {
+	items := []SubItem{}
+
+	bb.db.View(func(tx *bolt.Tx) error {
+		members := boltZsetRangeByScore(tx, boltSubsIndex, true, -1, cursor, number)
+		for _, m := range members {
+			item := SubItem{}
+			if err := boltHashGet(tx, boltSubsItemHash, m, &item); err != nil {
+				logs.Warn(err).Rid(rid).Str("member", m).Msg("boltHashGet fail")
+				continue
+			}
+			logs.Debug().Rid(rid).Str("chan", item.ChannelUrl).Int64("msgid", item.Msgid).Send()
+			items = append(items, item)
+		}
+		return nil
+	})
+
+	return finishQuery(items)
+}
+
+func (bb *boltBackend) SetItemSubs(rid, member string, subs SubFetchList) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		item := SubItem{}
+		if err := boltHashGet(tx, boltSubsItemHash, member, &item); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Msg("boltHashGet fail")
+			return err
+		}
+		item.Subs = subs
+		return boltHashSet(tx, boltSubsItemHash, member, &item)
+	})
+}
+
+func (bb *boltBackend) SetItemStatus(rid, member, status string, checkedAt int64) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		item := SubItem{}
+		if err := boltHashGet(tx, boltSubsItemHash, member, &item); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Msg("boltHashGet fail")
+			return err
+		}
+		item.Status, item.CheckedAt = status, checkedAt
+		return boltHashSet(tx, boltSubsItemHash, member, &item)
+	})
+}
+
+func (bb *boltBackend) AddCheckRecord(rid, member string, rec *CheckRecord) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		recs := []CheckRecord{}
+		boltHashGet(tx, boltCheckRecord, member, &recs)
+		recs = append([]CheckRecord{*rec}, recs...)
+		if len(recs) > maxCheckRecords {
+			recs = recs[:maxCheckRecords]
+		}
+		return boltHashSet(tx, boltCheckRecord, member, recs)
+	})
+}
+
+func (bb *boltBackend) GetCheckRecords(rid, member string) []CheckRecord {
+	recs := []CheckRecord{}
+	bb.db.View(func(tx *bolt.Tx) error {
+		return boltHashGet(tx, boltCheckRecord, member, &recs)
+	})
+	return recs
+}
+
+func (bb *boltBackend) AddItemSource(rid, member string, src ItemSource) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		item := SubItem{}
+		if err := boltHashGet(tx, boltSubsItemHash, member, &item); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Msg("boltHashGet fail")
+			return err
+		}
+		if !item.Sources.add(src) {
+			return ErrItemExisted
+		}
+		item.FirstSeen = item.Sources[0].PubDate
+		return boltHashSet(tx, boltSubsItemHash, member, &item)
+	})
+}
+
+func (bb *boltBackend) GetFingerprint(fp string) string {
+	member := ""
+	bb.db.View(func(tx *bolt.Tx) error {
+		return boltHashGet(tx, boltFingerprint, fp, &member)
+	})
+	return member
+}
+
+func (bb *boltBackend) SetFingerprint(fp, member string) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		return boltHashSet(tx, boltFingerprint, fp, member)
+	})
+}
+
+func (bb *boltBackend) IndexItem(rid, member string, score int64, indexes []string) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range indexes {
+			if err := boltZsetAdd(tx, boltIndexName(name), score, member); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bb *boltBackend) IndexCard(name string) int64 {
+	var n int64
+	bb.db.View(func(tx *bolt.Tx) error {
+		n = boltZsetCard(tx, boltIndexName(name))
+		return nil
+	})
+	return n
+}
+
+func (bb *boltBackend) ScanIndexes(rid string, groups [][]string, min, max, number int64) (int64, []SubItem) {
+	var nxt int64
+	var items []SubItem
+	bb.db.View(func(tx *bolt.Tx) error {
+		nxt, items = scanIndexes(rid, boltIndexReader{tx}, groups, min, max, number)
+		return nil
+	})
+	return finishScan(nxt, items)
+}
+
+func (bb *boltBackend) CountIndexes(groups [][]string, min, max int64) int64 {
+	var n int64
+	bb.db.View(func(tx *bolt.Tx) error {
+		n = countIndexes(boltIndexReader{tx}, groups, min, max)
+		return nil
+	})
+	return n
+}
+
+type boltIndexReader struct {
+	tx *bolt.Tx
+}
+
+func (br boltIndexReader) card(name string) int64 {
+	return boltZsetCard(br.tx, boltIndexName(name))
+}
+
+func (br boltIndexReader) rangeDesc(name string, min, max, count int64) []indexEntry {
+	name = boltIndexName(name)
+	res := []indexEntry{}
+	for _, m := range boltZsetRangeByScore(br.tx, name, true, min, max, count) {
+		score, _ := boltZsetScore(br.tx, name, m)
+		res = append(res, indexEntry{member: m, score: score})
+	}
+	return res
+}
+
+func (br boltIndexReader) has(name, member string) bool {
+	_, ok := boltZsetScore(br.tx, boltIndexName(name), member)
+	return ok
+}
+
+func (br boltIndexReader) item(member string) (SubItem, error) {
+	item := SubItem{}
+	err := boltHashGet(br.tx, boltSubsItemHash, member, &item)
+	return item, err
+}
+
+// 主索引以外的二级索引直接以逻辑名作为zset名
+func boltIndexName(name string) string {
+	if name == mainIndex {
+		return boltSubsIndex
+	}
+	return name
+}
+
+func (bb *boltBackend) SaveDelivery(d *WebhookDelivery) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		if err := boltHashSet(tx, boltWebhookRetry, d.ID, d); err != nil {
+			return err
+		}
+		return boltZsetAdd(tx, boltWebhookRetry, d.NextAt, d.ID)
+	})
+}
+
+func (bb *boltBackend) DueDeliveries(now, count int64) []WebhookDelivery {
+	res := []WebhookDelivery{}
+	bad := []string{}
+	bb.db.View(func(tx *bolt.Tx) error {
+		for _, id := range boltZsetRangeByScore(tx, boltWebhookRetry, false, 0, now+1, count) {
+			d := WebhookDelivery{}
+			if err := boltHashGet(tx, boltWebhookRetry, id, &d); err != nil || d.ID == "" {
+				logs.Warn(err).Str("id", id).Msg("boltHashGet fail")
+				bad = append(bad, id)
+				continue
+			}
+			res = append(res, d)
+		}
+		return nil
+	})
+	for _, id := range bad { // 数据已丢失或损坏，删除，否则会一直占据到期队列的前面
+		bb.DelDelivery(id)
+	}
+	return res
+}
+
+func (bb *boltBackend) DelDelivery(id string) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		if err := boltZsetRem(tx, boltWebhookRetry, id); err != nil {
+			return err
+		}
+		return boltHashDel(tx, boltWebhookRetry, id)
+	})
+}
+
+func (bb *boltBackend) GetChannelPts(chanid int64) int {
+	pts := 0
+	bb.db.View(func(tx *bolt.Tx) error {
+		return boltHashGet(tx, boltChannelPts, strconv.FormatInt(chanid, 10), &pts)
+	})
+	return pts
+}
+
+func (bb *boltBackend) SetChannelPts(chanid int64, pts int) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		return boltHashSet(tx, boltChannelPts, strconv.FormatInt(chanid, 10), pts)
+	})
+}
+
+func (bb *boltBackend) GetRelayed(target int64, member string) int {
+	msgid := 0
+	bb.db.View(func(tx *bolt.Tx) error {
+		return boltHashGet(tx, boltRelayPrefix+strconv.FormatInt(target, 10), member, &msgid)
+	})
+	return msgid
+}
+
+func (bb *boltBackend) SetRelayed(target int64, member string, msgid int) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		return boltHashSet(tx, boltRelayPrefix+strconv.FormatInt(target, 10), member, msgid)
+	})
+}
+
+func (bb *boltBackend) SaveChannel(c *ChannelConf) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		return boltHashSet(tx, boltChannels, c.Name, c)
+	})
+}
+
+func (bb *boltBackend) GetChannels() []ChannelConf {
+	res := []ChannelConf{}
+	bb.db.View(func(tx *bolt.Tx) error {
+		return boltHashForEach(tx, boltChannels, func(key, val []byte) error {
+			c := ChannelConf{}
+			if err := json.Unmarshal(val, &c); err != nil {
+				logs.Warn(err).Str("name", string(key)).Msg("unmarshal channel fail")
+				return nil
+			}
+			res = append(res, c)
+			return nil
+		})
+	})
+	return res
+}
+
+func (bb *boltBackend) GetCandidate(key string) (Candidate, bool) {
+	c := Candidate{}
+	err := bb.db.View(func(tx *bolt.Tx) error {
+		return boltHashGet(tx, boltDiscovery, key, &c)
+	})
+	return c, err == nil
+}
+
+func (bb *boltBackend) SaveCandidate(c *Candidate) error {
+	return bb.db.Update(func(tx *bolt.Tx) error {
+		return boltHashSet(tx, boltDiscovery, c.Key, c)
+	})
+}
+
+func (bb *boltBackend) GetCandidates() []Candidate {
+	res := []Candidate{}
+	bb.db.View(func(tx *bolt.Tx) error {
+		return boltHashForEach(tx, boltDiscovery, func(key, val []byte) error {
+			c := Candidate{}
+			if err := json.Unmarshal(val, &c); err != nil {
+				logs.Warn(err).Str("key", string(key)).Msg("unmarshal candidate fail")
+				return nil
+			}
+			res = append(res, c)
+			return nil
+		})
+	})
+	return res
+}
+
+// score编码为8字节大端，符号位取反保证负数排在前面
+func boltScoreKey(score int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(score)^(1<<63))
+	return key
+}
+
+func boltScoreDecode(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key[:8]) ^ (1 << 63))
+}
+
+func boltZsetAdd(tx *bolt.Tx, name string, score int64, member string) error {
+	zb, err := tx.CreateBucketIfNotExists([]byte(boltZsetPrefix + name))
+	if err != nil {
+		return err
+	}
+	mb, err := tx.CreateBucketIfNotExists([]byte(boltZsetMPrefix + name))
+	if err != nil {
+		return err
+	}
+
+	if old := mb.Get([]byte(member)); old != nil { // 更新score时先删除旧的排序key
+		zb.Delete(append(boltScoreKey(boltScoreDecode(old)), member...))
+	} else if err := boltZsetSetCard(tx, name, boltZsetCard(tx, name)+1); err != nil {
+		return err
+	}
+	if err := zb.Put(append(boltScoreKey(score), member...), nil); err != nil {
+		return err
+	}
+	return mb.Put([]byte(member), boltScoreKey(score))
+}
+
+func boltZsetRem(tx *bolt.Tx, name string, member string) error {
+	zb, mb := tx.Bucket([]byte(boltZsetPrefix+name)), tx.Bucket([]byte(boltZsetMPrefix+name))
+	if zb == nil || mb == nil {
+		return nil
+	}
+	old := mb.Get([]byte(member))
+	if old == nil {
+		return nil
+	}
+	if err := boltZsetSetCard(tx, name, boltZsetCard(tx, name)-1); err != nil {
+		return err
+	}
+	if err := zb.Delete(append(boltScoreKey(boltScoreDecode(old)), member...)); err != nil {
+		return err
+	}
+	return mb.Delete([]byte(member))
+}
+
+func boltZsetScore(tx *bolt.Tx, name string, member string) (int64, bool) {
+	mb := tx.Bucket([]byte(boltZsetMPrefix + name))
+	if mb == nil {
+		return 0, false
+	}
+	v := mb.Get([]byte(member))
+	if v == nil {
+		return 0, false
+	}
+	return boltScoreDecode(v), true
+}
+
+// boltZsetCard 成员数，升级前没有计数的zset才遍历统计，之后第一次写入时补上计数
+func boltZsetCard(tx *bolt.Tx, name string) int64 {
+	if nb := tx.Bucket([]byte(boltZsetCount)); nb != nil {
+		if v := nb.Get([]byte(name)); len(v) == 8 {
+			return int64(binary.BigEndian.Uint64(v))
+		}
+	}
+	mb := tx.Bucket([]byte(boltZsetMPrefix + name))
+	if mb == nil {
+		return 0
+	}
+	return int64(mb.Stats().KeyN)
+}
+
+func boltZsetSetCard(tx *bolt.Tx, name string, n int64) error {
+	nb, err := tx.CreateBucketIfNotExists([]byte(boltZsetCount))
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(max(n, 0)))
+	return nb.Put([]byte(name), v)
+}
+
+// 与 redis.ZsetRangeByScore 语义一致：score 在 [min, max) 区间
+func boltZsetRangeByScore(tx *bolt.Tx, name string, rev bool, min, max, count int64) []string {
+	zb := tx.Bucket([]byte(boltZsetPrefix + name))
+	if zb == nil {
+		return nil
+	}
+
+	members := []string{}
+	minKey, maxKey := boltScoreKey(min), boltScoreKey(max)
+	c := zb.Cursor()
+
+	var k []byte
+	if rev {
+		if k, _ = c.Seek(maxKey); k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+	} else {
+		k, _ = c.Seek(minKey)
+	}
+
+	for ; k != nil && int64(len(members)) < count; k = boltStep(c, rev) {
+		sk := k[:8]
+		if rev && bytes.Compare(sk, minKey) < 0 {
+			break
+		}
+		if !rev && bytes.Compare(sk, maxKey) >= 0 {
+			break
+		}
+		members = append(members, string(k[8:]))
+	}
+	return members
+}
+
+func boltStep(c *bolt.Cursor, rev bool) []byte {
+	var k []byte
+	if rev {
+		k, _ = c.Prev()
+	} else {
+		k, _ = c.Next()
+	}
+	return k
+}
+
+func boltHashSet(tx *bolt.Tx, name, key string, val any) error {
+	hb, err := tx.CreateBucketIfNotExists([]byte(boltHashPrefix + name))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return hb.Put([]byte(key), data)
+}
+
+func boltHashGet(tx *bolt.Tx, name, key string, out any) error {
+	hb := tx.Bucket([]byte(boltHashPrefix + name))
+	if hb == nil {
+		return bolt.ErrBucketNotFound
+	}
+	data := hb.Get([]byte(key))
+	if data == nil {
+		return errBoltKeyNotFound
+	}
+	return json.Unmarshal(data, out)
+}
+
+func boltHashDel(tx *bolt.Tx, name, key string) error {
+	hb := tx.Bucket([]byte(boltHashPrefix + name))
+	if hb == nil {
+		return nil
+	}
+	return hb.Delete([]byte(key))
+}
+
+// boltHashForEach 遍历hash中的所有字段
+func boltHashForEach(tx *bolt.Tx, name string, fn func(key, val []byte) error) error {
+	hb := tx.Bucket([]byte(boltHashPrefix + name))
+	if hb == nil {
+		return nil
+	}
+	return hb.ForEach(fn)
+}
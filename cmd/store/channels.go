@@ -0,0 +1,104 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"tgfreesub/internal/logs"
+	"time"
+)
+
+const (
+	ChannelActive  = "active"  // 正在监控
+	ChannelPaused  = "paused"  // 暂停，保留PTS，恢复后补齐暂停期间的消息
+	ChannelDeleted = "deleted" // 已删除，保留登记避免重启时被 -names 重新加入
+)
+
+// ChannelConf 频道登记信息，Name 为频道名，私有频道为 +邀请码
+type ChannelConf struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Title     string `json:"title,omitempty"`
+	ChannelID int64  `json:"id,omitempty"`
+	AddedAt   int64  `json:"added_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// NormChannelName 去掉频道名的 https://t.me/、@ 等前缀
+func NormChannelName(name string) string {
+	name = strings.TrimSpace(name)
+	name = strings.TrimPrefix(name, "https://")
+	name = strings.TrimPrefix(name, "http://")
+	name = strings.TrimPrefix(name, "t.me/")
+	name = strings.TrimPrefix(name, "@")
+	return strings.TrimSuffix(name, "/")
+}
+
+// GetChannels 所有登记的频道(已删除的除外)，按添加时间排序
+func GetChannels() []ChannelConf {
+	cs := slices.DeleteFunc(backend.GetChannels(), func(c ChannelConf) bool { return c.Status == ChannelDeleted })
+	slices.SortFunc(cs, func(a, b ChannelConf) int {
+		return cmp.Or(cmp.Compare(a.AddedAt, b.AddedAt), cmp.Compare(a.Name, b.Name))
+	})
+	return cs
+}
+
+func GetChannel(name string) (ChannelConf, bool) {
+	for _, c := range GetChannels() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ChannelConf{}, false
+}
+
+// SaveChannel 新增或更新频道登记信息
+func SaveChannel(c *ChannelConf) error {
+	now := time.Now().Unix()
+	if c.AddedAt == 0 {
+		c.AddedAt = now
+	}
+	c.UpdatedAt = now
+	return backend.SaveChannel(c)
+}
+
+// DelChannel 把频道标记为已删除，重新添加时恢复
+func DelChannel(name string) error {
+	c, ok := GetChannel(name)
+	if !ok {
+		return nil
+	}
+	c.Status = ChannelDeleted
+	return SaveChannel(&c)
+}
+
+// SeedChannels 把启动参数中还没有登记的频道登记为 active，返回所有 active 的频道名；
+// 通过管理接口删除的频道不会被重新登记
+func SeedChannels(names []string) []string {
+	known := map[string]bool{}
+	for _, c := range backend.GetChannels() {
+		known[c.Name] = true
+	}
+	for _, name := range names {
+		name = NormChannelName(name)
+		if name == "" || known[name] {
+			continue
+		}
+		known[name] = true
+		if err := SaveChannel(&ChannelConf{Name: name, Status: ChannelActive}); err != nil {
+			logs.Warn(err).Str("name", name).Msg("SaveChannel fail")
+		}
+	}
+	return ActiveChannels()
+}
+
+// ActiveChannels 需要监控的频道名
+func ActiveChannels() []string {
+	names := []string{}
+	for _, c := range GetChannels() {
+		if c.Status == ChannelActive {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,34 @@
+package store
+
+const (
+	CandidateIgnored  = "ignored"  // 人工忽略，不再列出
+	CandidatePromoted = "promoted" // 已加入监控
+)
+
+// Candidate 被监控频道转发或提及、但还没有监控的频道
+type Candidate struct {
+	Key       string             `json:"key"`            // 小写的频道名，私有频道为 +邀请码，没有username时为 id:<频道id>
+	Name      string             `json:"name,omitempty"` // 可用于添加监控的频道名
+	ChannelID int64              `json:"id,omitempty"`
+	Title     string             `json:"title,omitempty"`
+	Seen      int64              `json:"seen"`     // 出现的消息数
+	Hits      int64              `json:"hits"`     // 其中通过过滤规则的消息数
+	Fwds      int64              `json:"fwds"`     // 转发次数
+	Mentions  int64              `json:"mentions"` // @提及或t.me链接次数
+	Sources   map[string][]int64 `json:"sources"`  // 来自哪些监控的频道，值为已统计的msgid(升序，只保留最近的)，重复拉取的消息不再计数
+	FirstSeen int64              `json:"first_seen"`
+	LastSeen  int64              `json:"last_seen"`
+	Status    string             `json:"status,omitempty"`
+}
+
+func GetCandidate(key string) (Candidate, bool) {
+	return backend.GetCandidate(key)
+}
+
+func SaveCandidate(c *Candidate) error {
+	return backend.SaveCandidate(c)
+}
+
+func GetCandidates() []Candidate {
+	return backend.GetCandidates()
+}
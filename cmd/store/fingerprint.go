@@ -0,0 +1,70 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"slices"
+	"strings"
+	"tgfreesub/cmd/extract"
+	"unicode"
+)
+
+// 内容过短时指纹容易误合并，不计算内容指纹
+const minFingerprintRunes = 16
+
+// 转发时各频道常会加上自己的频道链接、@用户名，计算指纹前去掉
+var reChannelRef = regexp.MustCompile(`(?i)(https?://)?(t\.me|telegram\.me)/\S+|@\w+`)
+
+// contentFingerprint 归一化后的消息内容指纹：去掉频道引用、标点、空白与大小写差异
+func contentFingerprint(text string) string {
+	text = reChannelRef.ReplaceAllString(text, "")
+	sb := strings.Builder{}
+	n := 0
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			n++
+		}
+	}
+	if n < minFingerprintRunes {
+		return ""
+	}
+	return "c:" + sha1Hex(sb.String())
+}
+
+// linkFingerprint 链接集合的指纹，与顺序及节点备注(#后面的部分)无关；
+// links 只应包含正文中的订阅/分享链接，按钮与隐藏超链接多为注册、推广链接，不参与计算
+func linkFingerprint(links []extract.Link) string {
+	urls := []string{}
+	for _, l := range links {
+		u, _, _ := strings.Cut(l.Url, "#")
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return ""
+	}
+	slices.Sort(urls)
+	return "l:" + sha1Hex(strings.Join(slices.Compact(urls), "\n"))
+}
+
+// dupFingerprint 判断重复的指纹，内容与链接集合都相同才认为是同一条消息；内容过短时不判断
+func dupFingerprint(contentFp, linkFp string) string {
+	if contentFp == "" {
+		return ""
+	}
+	return "d:" + sha1Hex(contentFp+"\n"+linkFp)
+}
+
+// memberChannel 从 频道名_msgid 中取出频道名
+func memberChannel(member string) string {
+	if i := strings.LastIndexByte(member, '_'); i >= 0 {
+		return member[:i]
+	}
+	return member
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,259 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"tgfreesub/internal/logs"
+)
+
+// 二级索引都是以消息score排序的zset，逻辑名如下，由各后端映射为自己的key
+const mainIndex = "main" // z_subs_index_v3
+
+const seqIndex = "seq" // 按入库顺序，score 为 SubItem.Seq
+
+func searchIndex(token string) string { return "search:" + token }
+func channelIndex(ch string) string   { return "chan:" + ch }
+func linkIndex(t string) string       { return "link:" + t }
+
+const (
+	scanStep = 50
+	scanMax  = 2000 // 一次查询最多扫描的候选消息数，超过时返回当前位置由客户端继续翻页
+)
+
+type indexEntry struct {
+	member string
+	score  int64
+}
+
+// indexReader 各后端提供的索引读取能力
+type indexReader interface {
+	card(name string) int64
+	rangeDesc(name string, min, max, count int64) []indexEntry // score 在 [min, max) 区间，从大到小
+	has(name, member string) bool
+	item(member string) (SubItem, error)
+}
+
+// itemIndexes 消息需要加入的二级索引
+func itemIndexes(item *SubItem, text string) []string {
+	indexes := []string{channelIndex(strings.TrimPrefix(item.ChannelUrl, "t.me/"))}
+	types := []string{}
+	for _, l := range item.Links {
+		types = append(types, string(l.Type))
+	}
+	slices.Sort(types)
+	for _, t := range slices.Compact(types) {
+		indexes = append(indexes, linkIndex(t))
+	}
+	for _, t := range Tokenize(text + "\n" + item.ChannelName) {
+		indexes = append(indexes, searchIndex(t))
+	}
+	return indexes
+}
+
+// scanIndexes 查询同时满足每一组条件的消息，组内的索引为“或”关系，组之间为“与”关系
+// 从总量最小的一组开始按score从大到小扫描，其他组只检查是否包含；返回下一页的位置，扫描完时为-1
+func scanIndexes(rid string, r indexReader, groups [][]string, min, max, number int64) (int64, []SubItem) {
+	items := []SubItem{}
+	if len(groups) == 0 || number <= 0 {
+		return -1, items
+	}
+
+	groupCard := func(g []string) (n int64) {
+		for _, name := range g {
+			n += r.card(name)
+		}
+		return n
+	}
+	slices.SortFunc(groups, func(a, b []string) int { return cmp.Compare(groupCard(a), groupCard(b)) })
+	drive, rest := groups[0], groups[1:]
+
+	cursor := max
+	for scanned := 0; scanned < scanMax; {
+		// 组内多个索引各取一页后合并，只用前scanStep条，剩下的下一轮会重新取到
+		entries := []indexEntry{}
+		exhausted := true
+		for _, name := range drive {
+			page := r.rangeDesc(name, min, cursor, scanStep)
+			exhausted = exhausted && len(page) < scanStep
+			entries = append(entries, page...)
+		}
+		slices.SortFunc(entries, func(a, b indexEntry) int {
+			return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(a.member, b.member))
+		})
+		entries = slices.CompactFunc(entries, func(a, b indexEntry) bool { return a.member == b.member })
+		if len(entries) > scanStep {
+			entries, exhausted = entries[:scanStep], false
+		}
+
+		for _, e := range entries {
+			cursor = e.score
+			scanned++
+			if !matchGroups(r, rest, e.member) {
+				continue
+			}
+			item, err := r.item(e.member)
+			if err != nil {
+				continue
+			}
+			items = append(items, item)
+			if int64(len(items)) >= number {
+				return cursor, items
+			}
+		}
+		if exhausted {
+			return -1, items
+		}
+	}
+	return cursor, items
+}
+
+// countIndexes score在 [min, max) 区间内、同时命中每组中任一索引的消息数，从最小的组开始逐条检查
+func countIndexes(r indexReader, groups [][]string, min, max int64) int64 {
+	if len(groups) == 0 {
+		return 0
+	}
+	if len(groups) == 1 && len(groups[0]) == 1 && min < 0 && max == int64(^uint64(0)>>1) {
+		return r.card(groups[0][0])
+	}
+	groupCard := func(g []string) (n int64) {
+		for _, name := range g {
+			n += r.card(name)
+		}
+		return n
+	}
+	slices.SortFunc(groups, func(a, b []string) int { return cmp.Compare(groupCard(a), groupCard(b)) })
+	drive, rest := groups[0], groups[1:]
+
+	var n int64
+	seen := map[string]bool{}
+	for _, name := range drive {
+		cursor := max
+		for {
+			page := r.rangeDesc(name, min, cursor, scanStep)
+			for _, e := range page {
+				cursor = e.score
+				if seen[e.member] {
+					continue
+				}
+				seen[e.member] = true
+				if matchGroups(r, rest, e.member) {
+					n++
+				}
+			}
+			if len(page) < scanStep {
+				break
+			}
+		}
+	}
+	return n
+}
+
+func matchGroups(r indexReader, groups [][]string, member string) bool {
+	for _, g := range groups {
+		if !slices.ContainsFunc(g, func(name string) bool { return r.has(name, member) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// 扫描结果的通用处理，nxt为最后扫描到的候选消息而不是最后一条结果
+func finishScan(nxt int64, items []SubItem) (int64, []SubItem) {
+	_, items = finishQuery(items)
+	return nxt, items
+}
+
+// ItemFilter /subs/list 的过滤条件，为空的条件不限制
+type ItemFilter struct {
+	Channels  []string // 任一频道
+	Since     int64    // 发布时间 >= Since
+	Until     int64    // 发布时间 <= Until
+	LinkTypes []string // 包含任一类型的链接
+}
+
+// QueryItemsBy 按条件查询，cursor 语义同 QuerySubItems
+func QueryItemsBy(rid string, f *ItemFilter, cursor, number int64) (int64, []SubItem) {
+	groups, lower, upper := f.scanArgs()
+	if cursor != 0 {
+		upper = min(upper, cursor)
+	}
+	return backend.ScanIndexes(rid, groups, lower, upper, number)
+}
+
+// CountItemsBy 符合条件的消息总数
+func CountItemsBy(f *ItemFilter) int64 {
+	groups, lower, upper := f.scanArgs()
+	return backend.CountIndexes(groups, lower, upper)
+}
+
+// Empty 没有任何过滤条件
+func (f *ItemFilter) Empty() bool {
+	return len(f.Channels) == 0 && len(f.LinkTypes) == 0 && f.Since <= 0 && f.Until <= 0
+}
+
+// scanArgs 过滤条件对应的索引组及score区间 [lower, upper)
+func (f *ItemFilter) scanArgs() (groups [][]string, lower, upper int64) {
+	if len(f.Channels) > 0 {
+		g := []string{}
+		for _, ch := range f.Channels {
+			g = append(g, channelIndex(strings.TrimPrefix(ch, "t.me/")))
+		}
+		groups = append(groups, g)
+	}
+	if len(f.LinkTypes) > 0 {
+		g := []string{}
+		for _, t := range f.LinkTypes {
+			g = append(g, linkIndex(t))
+		}
+		groups = append(groups, g)
+	}
+	if len(groups) == 0 {
+		groups = append(groups, []string{mainIndex})
+	}
+
+	lower, upper = -1, int64(^uint64(0)>>1)
+	if f.Since > 0 {
+		lower = (&SubItem{PubDate: f.Since}).calcScore()
+	}
+	if f.Until > 0 {
+		upper = (&SubItem{PubDate: f.Until + 1}).calcScore()
+	}
+	return groups, lower, upper
+}
+
+// CountByChannel 频道已保存的消息数
+func CountByChannel(ch string) int64 {
+	return backend.IndexCard(channelIndex(strings.TrimPrefix(ch, "t.me/")))
+}
+
+// CountByLinkType 包含该类型链接的消息数
+func CountByLinkType(t string) int64 {
+	return backend.IndexCard(linkIndex(t))
+}
+
+// ReindexItems 为已保存的消息重建二级索引，用于升级后补齐旧数据，返回处理的消息数
+func ReindexItems(rid string) int {
+	var cursor int64
+	n := 0
+	for {
+		nxt, items := QuerySubItems(rid, cursor, scanStep)
+		for i := range items {
+			item := &items[i]
+			if err := backend.IndexItem(rid, item.Member(), item.calcScore(), itemIndexes(item, PlainText(item.MsgContent))); err != nil {
+				logs.Warn(err).Rid(rid).Str("member", item.Member()).Msg("IndexItem fail")
+				continue
+			}
+			if item.Seq > 0 {
+				backend.IndexItem(rid, item.Member(), item.Seq, []string{seqIndex})
+			}
+			n++
+		}
+		if nxt < 0 || len(items) == 0 {
+			break
+		}
+		cursor = nxt
+	}
+	logs.Info().Rid(rid).Int("items", n).Msg("reindex done")
+	return n
+}
@@ -0,0 +1,320 @@
+package store
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"tgfreesub/internal/logs"
+	"tgfreesub/internal/redis"
+)
+
+const (
+	subsIndexKey               = "z_subs_index_v3"
+	subsItemKeyPrefix          = "h_subs_item_"
+	channelPtsKey              = "h_channel_pts"
+	relayMsgidKeyPrefix        = "h_relay_msgid_"
+	channelsKey                = "h_channels"
+	discoveryKey               = "h_discovery"
+	checkRecordKeyPrefix       = "l_check_record_"
+	fingerprintKey             = "h_subs_fingerprint"
+	webhookRetryIndexKey       = "z_webhook_retry"
+	webhookRetryKey            = "h_webhook_retry"
+	socreStartOffset     int64 = 1755692698000000
+)
+
+type rdsBackend struct {
+	rds *redis.RdsClient
+}
+
+func newRedisBackend(url string) (*rdsBackend, error) {
+	rds, err := redis.InitRedis(url)
+	if err != nil {
+		return nil, err
+	}
+	return &rdsBackend{rds: rds}, nil
+}
+
+func (rb *rdsBackend) Close() error {
+	return rb.rds.Close()
+}
+
+func (rb *rdsBackend) AddItem(rid string, item *SubItem) error {
+	// score := time.Now().UnixMicro() - socreStartOffset
+	score := item.calcScore()
+	member := item.Member()
+	rKey := subsItemKeyPrefix + member
+
+	if rb.rds.ZsetIsMember(subsIndexKey, member) {
+		logs.Trace().Rid(rid).Str("subsIndexKey", subsIndexKey).Str("member", member).Msg("had recored")
+		return ErrItemExisted
+	}
+
+	if err := rb.rds.HashSetAll(rKey, item); err != nil {
+		logs.Warn(err).Rid(rid).Str("rkey", rKey).Msg("HashSetAll fail")
+		return err
+	}
+
+	logs.Info().Rid(rid).Str("subsIndexKey", subsIndexKey).Str("member", member).Int64("score", score).Msg("add new record")
+	return rb.rds.ZsetAddMember(subsIndexKey, float64(score), member)
+}
+
+func (rb *rdsBackend) GetItemsTotal(_ string) int64 {
+	return rb.rds.ZsetCard(subsIndexKey)
+}
+
+func (rb *rdsBackend) QuerySubItems(rid string, cursor, number int64) (int64, []SubItem) {
+	members := rb.rds.ZsetRangeByScore(subsIndexKey, true, -1, cursor, number)
+	if members == nil {
+		return cursor, nil
+	}
+
+	items := []SubItem{}
+
+	for _, m := range members {
+		rKey := subsItemKeyPrefix + m
+		item := SubItem{}
+
+		if err := rb.rds.HashGetAll(rKey, &item); err != nil {
+			logs.Warn(err).Rid(rid).Str("rkey", rKey).Msg("HashGetAll fail")
+		} else {
+			logs.Debug().Rid(rid).Str("chan", item.ChannelUrl).Int64("msgid", item.Msgid).Send()
+			items = append(items, item)
+		}
+	}
+
+	return finishQuery(items)
+}
+
+func (rb *rdsBackend) GetChannelPts(chanid int64) int {
+	v, err := rb.rds.HashGetField(channelPtsKey, strconv.FormatInt(chanid, 10))
+	if err != nil {
+		return 0
+	}
+	pts, _ := strconv.Atoi(v)
+	return pts
+}
+
+func (rb *rdsBackend) SetChannelPts(chanid int64, pts int) error {
+	return rb.rds.HashSetField(channelPtsKey, strconv.FormatInt(chanid, 10), pts)
+}
+
+func (rb *rdsBackend) GetRelayed(target int64, member string) int {
+	v, err := rb.rds.HashGetField(relayMsgidKeyPrefix+strconv.FormatInt(target, 10), member)
+	if err != nil {
+		return 0
+	}
+	msgid, _ := strconv.Atoi(v)
+	return msgid
+}
+
+func (rb *rdsBackend) SetRelayed(target int64, member string, msgid int) error {
+	return rb.rds.HashSetField(relayMsgidKeyPrefix+strconv.FormatInt(target, 10), member, msgid)
+}
+
+func (rb *rdsBackend) SaveChannel(c *ChannelConf) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return rb.rds.HashSetField(channelsKey, c.Name, data)
+}
+
+func (rb *rdsBackend) GetChannels() []ChannelConf {
+	res := []ChannelConf{}
+	for name, v := range rb.rds.HashGetAllFields(channelsKey) {
+		c := ChannelConf{}
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			logs.Warn(err).Str("name", name).Msg("unmarshal channel fail")
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+func (rb *rdsBackend) GetCandidate(key string) (Candidate, bool) {
+	c := Candidate{}
+	v, err := rb.rds.HashGetField(discoveryKey, key)
+	if err != nil {
+		return c, false
+	}
+	return c, json.Unmarshal([]byte(v), &c) == nil
+}
+
+func (rb *rdsBackend) SaveCandidate(c *Candidate) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return rb.rds.HashSetField(discoveryKey, c.Key, data)
+}
+
+func (rb *rdsBackend) GetCandidates() []Candidate {
+	res := []Candidate{}
+	for key, v := range rb.rds.HashGetAllFields(discoveryKey) {
+		c := Candidate{}
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			logs.Warn(err).Str("key", key).Msg("unmarshal candidate fail")
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+func (rb *rdsBackend) SetItemSubs(rid, member string, subs SubFetchList) error {
+	rKey := subsItemKeyPrefix + member
+	if !rb.rds.CheckKeyExisted(rKey) {
+		logs.Warn(nil).Rid(rid).Str("rkey", rKey).Msg("item not existed")
+		return redis.Nil
+	}
+	return rb.rds.HashSetField(rKey, "subs", subs)
+}
+
+func (rb *rdsBackend) SetItemStatus(rid, member, status string, checkedAt int64) error {
+	rKey := subsItemKeyPrefix + member
+	if !rb.rds.CheckKeyExisted(rKey) {
+		logs.Warn(nil).Rid(rid).Str("rkey", rKey).Msg("item not existed")
+		return redis.Nil
+	}
+	return rb.rds.HashSetAll(rKey, map[string]any{"status": status, "checked_at": checkedAt})
+}
+
+func (rb *rdsBackend) AddCheckRecord(rid, member string, rec *CheckRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return rb.rds.ListPushTrim(checkRecordKeyPrefix+member, data, maxCheckRecords)
+}
+
+func (rb *rdsBackend) GetCheckRecords(rid, member string) []CheckRecord {
+	recs := []CheckRecord{}
+	for _, v := range rb.rds.ListRange(checkRecordKeyPrefix+member, 0, -1) {
+		rec := CheckRecord{}
+		if err := json.Unmarshal([]byte(v), &rec); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Msg("unmarshal check record fail")
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func (rb *rdsBackend) AddItemSource(rid, member string, src ItemSource) error {
+	rKey := subsItemKeyPrefix + member
+	item := SubItem{}
+	if err := rb.rds.HashGetAll(rKey, &item); err != nil {
+		logs.Warn(err).Rid(rid).Str("rkey", rKey).Msg("HashGetAll fail")
+		return err
+	}
+	if !item.Sources.add(src) {
+		return ErrItemExisted
+	}
+	return rb.rds.HashSetAll(rKey, map[string]any{"sources": item.Sources, "first_seen": item.Sources[0].PubDate})
+}
+
+func (rb *rdsBackend) GetFingerprint(fp string) string {
+	member, _ := rb.rds.HashGetField(fingerprintKey, fp)
+	return member
+}
+
+func (rb *rdsBackend) SetFingerprint(fp, member string) error {
+	return rb.rds.HashSetField(fingerprintKey, fp, member)
+}
+
+func (rb *rdsBackend) SaveDelivery(d *WebhookDelivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err := rb.rds.HashSetField(webhookRetryKey, d.ID, data); err != nil {
+		return err
+	}
+	return rb.rds.ZsetAddMember(webhookRetryIndexKey, float64(d.NextAt), d.ID)
+}
+
+func (rb *rdsBackend) DueDeliveries(now, count int64) []WebhookDelivery {
+	res := []WebhookDelivery{}
+	for _, id := range rb.rds.ZsetRangeByScore(webhookRetryIndexKey, false, 0, now+1, count) {
+		v, err := rb.rds.HashGetField(webhookRetryKey, id)
+		if err != nil {
+			logs.Warn(err).Str("id", id).Msg("HashGetField fail")
+			rb.rds.ZsetDelMember(webhookRetryIndexKey, id) // 数据已丢失，不再重试
+			continue
+		}
+		d := WebhookDelivery{}
+		if err := json.Unmarshal([]byte(v), &d); err != nil {
+			logs.Warn(err).Str("id", id).Msg("unmarshal delivery fail")
+			rb.DelDelivery(id) // 数据已损坏，删除，否则会一直占据到期队列的前面
+			continue
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+func (rb *rdsBackend) DelDelivery(id string) error {
+	if err := rb.rds.ZsetDelMember(webhookRetryIndexKey, id); err != nil {
+		return err
+	}
+	return rb.rds.HashDelField(webhookRetryKey, id)
+}
+
+func (rb *rdsBackend) IndexItem(rid, member string, score int64, indexes []string) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(indexes))
+	for _, name := range indexes {
+		keys = append(keys, rdsIndexKey(name))
+	}
+	return rb.rds.ZsetAddMemberToKeys(keys, float64(score), member)
+}
+
+func (rb *rdsBackend) IndexCard(name string) int64 {
+	return rb.rds.ZsetCard(rdsIndexKey(name))
+}
+
+func (rb *rdsBackend) ScanIndexes(rid string, groups [][]string, min, max, number int64) (int64, []SubItem) {
+	return finishScan(scanIndexes(rid, rdsIndexReader{rb.rds}, groups, min, max, number))
+}
+
+func (rb *rdsBackend) CountIndexes(groups [][]string, min, max int64) int64 {
+	return countIndexes(rdsIndexReader{rb.rds}, groups, min, max)
+}
+
+type rdsIndexReader struct {
+	rds *redis.RdsClient
+}
+
+func (rr rdsIndexReader) card(name string) int64 {
+	return rr.rds.ZsetCard(rdsIndexKey(name))
+}
+
+func (rr rdsIndexReader) rangeDesc(name string, min, max, count int64) []indexEntry {
+	res := []indexEntry{}
+	for _, z := range rr.rds.ZsetRangeByScoreWithScores(rdsIndexKey(name), true, min, max, count) {
+		member, _ := z.Member.(string)
+		res = append(res, indexEntry{member: member, score: int64(z.Score)})
+	}
+	return res
+}
+
+func (rr rdsIndexReader) has(name, member string) bool {
+	return rr.rds.ZsetIsMember(rdsIndexKey(name), member)
+}
+
+func (rr rdsIndexReader) item(member string) (SubItem, error) {
+	item := SubItem{}
+	err := rr.rds.HashGetAll(subsItemKeyPrefix+member, &item)
+	return item, err
+}
+
+// 二级索引的逻辑名 search:xxx chan:xxx link:xxx 对应 z_search_xxx z_chan_xxx z_link_xxx
+func rdsIndexKey(name string) string {
+	if name == mainIndex {
+		return subsIndexKey
+	}
+	return "z_" + strings.Replace(name, ":", "_", 1)
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// MsgEntity 消息格式实体，Offset/Length 以 UTF-16 code unit 计算
+type MsgEntity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	Url    string `json:"url,omitempty"`
+	Lang   string `json:"lang,omitempty"`
+}
+
+type entityTag struct {
+	pos   int
+	open  bool
+	order int // 开标签按出现顺序，闭标签逆序，保证嵌套正确
+	tag   string
+}
+
+// renderContent 将消息文本与实体渲染为html，换行沿用 "</ p>" 的约定
+func renderContent(text string, ents []MsgEntity) string {
+	u16 := utf16.Encode([]rune(text))
+
+	tags := []entityTag{}
+	for i, e := range nestEntities(ents, len(u16)) {
+		end := e.Offset + e.Length
+		seg := string(utf16.Decode(u16[e.Offset:end]))
+
+		var open, close string
+		switch e.Type {
+		case "text_url":
+			if !safeHref(e.Url) {
+				continue
+			}
+			open, close = linkTag(e.Url), "</a>"
+		case "url":
+			href := seg
+			if !strings.Contains(href, "://") {
+				href = "https://" + href
+			}
+			if !safeHref(href) {
+				continue
+			}
+			open, close = linkTag(href), "</a>"
+		case "mention":
+			open, close = linkTag("https://t.me/"+strings.TrimPrefix(seg, "@")), "</a>"
+		case "code":
+			open, close = "<code>", "</code>"
+		case "pre":
+			if e.Lang != "" {
+				open = `<pre data-lang="` + html.EscapeString(e.Lang) + `">`
+			} else {
+				open = "<pre>"
+			}
+			close = "</pre>"
+		case "spoiler":
+			open, close = `<span class="spoiler">`, "</span>"
+		default:
+			continue
+		}
+		tags = append(tags,
+			entityTag{pos: e.Offset, open: true, order: i, tag: open},
+			entityTag{pos: end, open: false, order: i, tag: close})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		if a.pos != b.pos {
+			return a.pos < b.pos
+		}
+		if a.open != b.open {
+			return !a.open // 同一位置先闭合再打开
+		}
+		if a.open {
+			return a.order < b.order
+		}
+		return a.order > b.order
+	})
+
+	var sb strings.Builder
+	last := 0
+	for _, t := range tags {
+		sb.WriteString(escapeText(u16[last:t.pos]))
+		sb.WriteString(t.tag)
+		last = t.pos
+	}
+	sb.WriteString(escapeText(u16[last:]))
+
+	return sb.String()
+}
+
+func isLinkEntity(t string) bool {
+	return t == "text_url" || t == "url" || t == "mention"
+}
+
+// nestEntities 按位置排序，与外层实体部分重叠的截断到外层的结尾，链接内的链接丢弃，保证标签嵌套正确
+func nestEntities(ents []MsgEntity, size int) []MsgEntity {
+	sorted := []MsgEntity{}
+	for _, e := range ents {
+		if e.Offset >= 0 && e.Length > 0 && e.Offset+e.Length <= size {
+			sorted = append(sorted, e)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Offset != sorted[j].Offset {
+			return sorted[i].Offset < sorted[j].Offset
+		}
+		return sorted[i].Length > sorted[j].Length // 同一位置长的在外层
+	})
+
+	res := []MsgEntity{}
+	stack := []MsgEntity{} // 当前位置所在的外层实体
+	for _, e := range sorted {
+		for len(stack) > 0 && stack[len(stack)-1].Offset+stack[len(stack)-1].Length <= e.Offset {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			outer := stack[len(stack)-1]
+			e.Length = min(e.Length, outer.Offset+outer.Length-e.Offset)
+			if isLinkEntity(e.Type) && slices.ContainsFunc(stack, func(o MsgEntity) bool { return isLinkEntity(o.Type) }) {
+				continue
+			}
+		}
+		res = append(res, e)
+		stack = append(stack, e)
+	}
+	return res
+}
+
+// safeHref 只允许 http/https/tg 链接，避免 javascript: 等被渲染到页面中
+func safeHref(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "tg":
+		return true
+	}
+	return false
+}
+
+func escapeText(u16 []uint16) string {
+	return strings.ReplaceAll(html.EscapeString(string(utf16.Decode(u16))), "\n", "</ p>")
+}
+
+func linkTag(href string) string {
+	return `<a href="` + html.EscapeString(href) + `" target="_blank" rel="noopener noreferrer">`
+}
+
+var reHtmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// PlainText 把渲染后的MsgContent还原为纯文本
+func PlainText(content string) string {
+	content = strings.ReplaceAll(content, "</ p>", "\n")
+	return html.UnescapeString(reHtmlTag.ReplaceAllString(content, ""))
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxItemTokens = 512 // 每条消息最多索引的词数
+	maxTokenRunes = 32
+)
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Tokenize 分词：中日韩文字按单字+相邻二字切分，其他按字母数字连续串切分，统一小写并去重
+func Tokenize(text string) []string {
+	tokens := []string{}
+	seen := map[string]bool{}
+	add := func(t string) {
+		if !seen[t] && len(tokens) < maxItemTokens {
+			seen[t] = true
+			tokens = append(tokens, t)
+		}
+	}
+
+	for _, run := range splitRuns(strings.ToLower(text)) {
+		rs := []rune(run)
+		if !isCJK(rs[0]) {
+			if len(rs) >= 2 && len(rs) <= maxTokenRunes {
+				add(run)
+			}
+			continue
+		}
+		for i := range rs {
+			add(string(rs[i]))
+			if i+1 < len(rs) {
+				add(string(rs[i : i+2]))
+			}
+		}
+	}
+	return tokens
+}
+
+// queryTokens 查询词的分词：中文只取二字词(单字时取单字)，减少需要求交集的倒排表
+func queryTokens(q string) []string {
+	tokens := []string{}
+	for _, run := range splitRuns(strings.ToLower(q)) {
+		rs := []rune(run)
+		switch {
+		case !isCJK(rs[0]):
+			if len(rs) >= 2 && len(rs) <= maxTokenRunes {
+				tokens = append(tokens, run)
+			}
+		case len(rs) == 1:
+			tokens = append(tokens, run)
+		default:
+			for i := 0; i+1 < len(rs); i++ {
+				tokens = append(tokens, string(rs[i:i+2]))
+			}
+		}
+	}
+	slices.Sort(tokens)
+	return slices.Compact(tokens)
+}
+
+// 切分为连续的中日韩文字串或字母数字串
+func splitRuns(text string) []string {
+	runs := []string{}
+	start, cjk := -1, false
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if start >= 0 && (!word || isCJK(r) != cjk) {
+			runs = append(runs, text[start:i])
+			start = -1
+		}
+		if word && start < 0 {
+			start, cjk = i, isCJK(r)
+		}
+	}
+	if start >= 0 {
+		runs = append(runs, text[start:])
+	}
+	return runs
+}
+
+func searchGroups(q string) [][]string {
+	groups := [][]string{}
+	for _, t := range queryTokens(q) {
+		groups = append(groups, []string{searchIndex(t)})
+	}
+	return groups
+}
+
+// SearchItems 按关键词搜索，多个词之间为“与”关系，结果按时间倒序；cursor 语义同 QuerySubItems
+func SearchItems(rid, q string, cursor, number int64) (int64, []SubItem) {
+	groups := searchGroups(q)
+	if len(groups) == 0 {
+		return -1, nil
+	}
+	if cursor == 0 {
+		cursor = int64(^uint64(0) >> 1)
+	}
+	return backend.ScanIndexes(rid, groups, -1, cursor, number)
+}
+
+// CountSearch 命中关键词的消息总数
+func CountSearch(q string) int64 {
+	return backend.CountIndexes(searchGroups(q), -1, int64(^uint64(0)>>1))
+}
+
+// Highlight 用<mark>标出html内容中命中的查询词，只处理标签之外的文本；
+// 查询词按索引的方式分词，中文为二字词，其他为完整的单词
+func Highlight(content, q string) string {
+	terms := queryTokens(q)
+	if len(terms) == 0 {
+		return content
+	}
+	// 长词优先，避免短词把长词拆开
+	slices.SortFunc(terms, func(a, b string) int { return len(b) - len(a) })
+
+	sb := strings.Builder{}
+	for content != "" {
+		lt := strings.IndexByte(content, '<')
+		if lt < 0 {
+			lt = len(content)
+		}
+		// 相邻的二字词合并为一个<mark>
+		sb.WriteString(strings.ReplaceAll(markTerms(content[:lt], terms), "</mark><mark>", ""))
+		content = content[lt:]
+		if gt := strings.IndexByte(content, '>'); gt >= 0 {
+			sb.WriteString(content[:gt+1])
+			content = content[gt+1:]
+		} else {
+			sb.WriteString(content)
+			content = ""
+		}
+	}
+	return sb.String()
+}
+
+// wordBound 非中文的词只在完整单词上标出，与索引一致
+func wordBound(text string, start, end int) bool {
+	r, _ := utf8.DecodeRuneInString(text[start:])
+	if isCJK(r) {
+		return true
+	}
+	inWord := func(r rune) bool { return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !isCJK(r) }
+	if prev, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && inWord(prev) {
+		return false
+	}
+	if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && inWord(next) {
+		return false
+	}
+	return true
+}
+
+func markTerms(text string, terms []string) string {
+	if text == "" {
+		return text
+	}
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) { // 大小写转换改变了字节长度，无法按位置对应，直接忽略大小写差异
+		lower = text
+	}
+
+	sb := strings.Builder{}
+	for i := 0; i < len(text); {
+		if text[i] == '&' { // 跳过html实体，如 &amp;
+			if semi := strings.IndexByte(text[i:], ';'); semi > 0 {
+				sb.WriteString(text[i : i+semi+1])
+				i += semi + 1
+				continue
+			}
+		}
+		matched := ""
+		for _, t := range terms {
+			if strings.HasPrefix(lower[i:], t) && wordBound(lower, i, i+len(t)) {
+				matched = text[i : i+len(t)]
+				break
+			}
+		}
+		if matched != "" {
+			sb.WriteString("<mark>" + matched + "</mark>")
+			i += len(matched)
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		sb.WriteString(text[i : i+size])
+		i += size
+	}
+	return sb.String()
+}
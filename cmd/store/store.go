@@ -1,92 +1,362 @@
 package store
 
 import (
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
+	"tgfreesub/cmd/extract"
 	"tgfreesub/internal/logs"
-	"tgfreesub/internal/redis"
+	"time"
 )
 
 type SubItem struct {
-	ChannelUrl  string `json:"url,omitempty" redis:"url,omitempty"`
-	ChannelName string `json:"name,omitempty" redis:"name,omitempty"`
-	PubDate     int64  `json:"date,omitempty" redis:"date,omitempty"`
-	MsgContent  string `json:"content,omitempty" redis:"content,omitempty"`
-	ChannelID   int64  `json:"chanid,omitempty" redis:"chanid,omitempty"`
-	Msgid       int64  `json:"msgid,omitempty" redis:"msgid,omitempty"`
+	ChannelUrl  string       `json:"url,omitempty" redis:"url,omitempty"`
+	ChannelName string       `json:"name,omitempty" redis:"name,omitempty"`
+	PubDate     int64        `json:"date,omitempty" redis:"date,omitempty"`
+	MsgContent  string       `json:"content,omitempty" redis:"content,omitempty"`
+	ChannelID   int64        `json:"chanid,omitempty" redis:"chanid,omitempty"`
+	Msgid       int64        `json:"msgid,omitempty" redis:"msgid,omitempty"`
+	Links       LinkList     `json:"links,omitempty" redis:"links,omitempty"`
+	Buttons     ButtonList   `json:"buttons,omitempty" redis:"buttons,omitempty"`
+	Subs        SubFetchList `json:"subs,omitempty" redis:"subs,omitempty"`     // 订阅链接的抓取结果
+	Status      string       `json:"status,omitempty" redis:"status,omitempty"` // 订阅状态：alive dead expired
+	CheckedAt   int64        `json:"checked_at,omitempty" redis:"checked_at,omitempty"`
+	ContentFp   string       `json:"content_fp,omitempty" redis:"content_fp,omitempty"` // 内容指纹，用于跨频道去重
+	LinkFp      string       `json:"link_fp,omitempty" redis:"link_fp,omitempty"`       // 链接集合指纹
+	Sources     SourceList   `json:"sources,omitempty" redis:"sources,omitempty"`       // 发布过该内容的所有频道
+	FirstSeen   int64        `json:"first_seen,omitempty" redis:"first_seen,omitempty"` // 最早发布时间
+	Seq         int64        `json:"seq,omitempty" redis:"seq,omitempty"`               // 入库顺序，用作推送的事件id
+	Entities    []MsgEntity  `json:"-" redis:"-"`                                       // 仅用于入库时渲染MsgContent
 	// Score       int64  `json:"-,omitempty" redis:"score,omitempty"`
 }
 
+// LinkList 在redis hash中以json字符串保存
+type LinkList []extract.Link
+
+func (ll LinkList) MarshalBinary() ([]byte, error) {
+	return json.Marshal(ll)
+}
+func (ll *LinkList) ScanRedis(s string) error {
+	return json.Unmarshal([]byte(s), ll)
+}
+
+// MsgButton 消息下方的链接按钮
+type MsgButton struct {
+	Text string `json:"text"`
+	Url  string `json:"url"`
+}
+
+// ButtonList 在redis hash中以json字符串保存
+type ButtonList []MsgButton
+
+func (bl ButtonList) MarshalBinary() ([]byte, error) {
+	return json.Marshal(bl)
+}
+func (bl *ButtonList) ScanRedis(s string) error {
+	return json.Unmarshal([]byte(s), bl)
+}
+
+// ItemSource 发布过同一内容的频道消息
+type ItemSource struct {
+	ChannelUrl  string `json:"url"`
+	ChannelName string `json:"name,omitempty"`
+	Msgid       int64  `json:"msgid"`
+	PubDate     int64  `json:"date"`
+}
+
+// SourceList 在redis hash中以json字符串保存
+type SourceList []ItemSource
+
+func (sl SourceList) MarshalBinary() ([]byte, error) {
+	return json.Marshal(sl)
+}
+func (sl *SourceList) ScanRedis(s string) error {
+	return json.Unmarshal([]byte(s), sl)
+}
+
+// add 追加来源并按发布时间排序，已存在时返回false
+func (sl *SourceList) add(src ItemSource) bool {
+	for _, s := range *sl {
+		if s.ChannelUrl == src.ChannelUrl && s.Msgid == src.Msgid {
+			return false
+		}
+	}
+	*sl = append(*sl, src)
+	slices.SortStableFunc(*sl, func(a, b ItemSource) int { return cmp.Compare(a.PubDate, b.PubDate) })
+	return true
+}
+
+var (
+	ErrItemExisted = errors.New("item existed")
+	ErrItemMerged  = errors.New("item merged") // 其他频道已发布过相同内容，只记录来源
+)
+
 const (
-	subsIndexKey            = "z_subs_index_v3"
-	subsItemKeyPrefix       = "h_subs_item_"
-	socreStartOffset  int64 = 1755692698000000
+	StatusAlive   = "alive"
+	StatusDead    = "dead"
+	StatusExpired = "expired"
 )
 
-var rds *redis.RdsClient
+// CheckRecord 一次订阅检测的结果
+type CheckRecord struct {
+	Url        string `json:"url"`
+	CheckedAt  int64  `json:"checked_at"`
+	HttpStatus int    `json:"http_status,omitempty"`
+	Nodes      int    `json:"nodes"`
+	Upload     int64  `json:"upload,omitempty"`
+	Download   int64  `json:"download,omitempty"`
+	Total      int64  `json:"total,omitempty"`
+	Expire     int64  `json:"expire,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 每条消息保留的检测记录数
+const maxCheckRecords = 50
+
+// WebhookDelivery 投递失败等待重试的webhook请求
+type WebhookDelivery struct {
+	ID        string          `json:"id"`
+	Hook      string          `json:"hook"` // webhook名称
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"` // 已尝试次数
+	NextAt    int64           `json:"next_at"`  // 下次重试时间
+	CreatedAt int64           `json:"created_at"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// SubFetch 订阅链接的抓取结果
+type SubFetch struct {
+	Url       string   `json:"url"`
+	Format    string   `json:"format,omitempty"` // base64 clash singbox plain
+	Nodes     []string `json:"nodes,omitempty"`  // 节点分享链接
+	Upload    int64    `json:"upload,omitempty"` // subscription-userinfo, 单位字节
+	Download  int64    `json:"download,omitempty"`
+	Total     int64    `json:"total,omitempty"`
+	Expire    int64    `json:"expire,omitempty"` // unix时间戳
+	FetchedAt int64    `json:"fetched_at"`
+}
+
+// SubFetchList 在redis hash中以json字符串保存
+type SubFetchList []SubFetch
+
+func (sl SubFetchList) MarshalBinary() ([]byte, error) {
+	return json.Marshal(sl)
+}
+func (sl *SubFetchList) ScanRedis(s string) error {
+	return json.Unmarshal([]byte(s), sl)
+}
+
+// Backend 存储后端，目前支持redis与本地文件(bbolt)两种，按用途分为以下几组
+type Backend interface {
+	ItemBackend
+	IndexBackend
+	ChannelBackend
+	DeliveryBackend
+	RelayBackend
+	DiscoveryBackend
+	Close() error
+}
+
+// ItemBackend 消息本身：入库、分页查询、订阅抓取/检测结果、去重用的指纹与来源
+type ItemBackend interface {
+	AddItem(rid string, item *SubItem) error
+	GetItemsTotal(rid string) int64
+	QuerySubItems(rid string, cursor, number int64) (int64, []SubItem)
+	SetItemSubs(rid, member string, subs SubFetchList) error
+	SetItemStatus(rid, member, status string, checkedAt int64) error
+	AddCheckRecord(rid, member string, rec *CheckRecord) error
+	GetCheckRecords(rid, member string) []CheckRecord
+	AddItemSource(rid, member string, src ItemSource) error
+	GetFingerprint(fp string) string
+	SetFingerprint(fp, member string) error
+}
+
+// IndexBackend 二级索引(频道、链接类型、搜索词、入库顺序)
+type IndexBackend interface {
+	IndexItem(rid, member string, score int64, indexes []string) error
+	IndexCard(name string) int64
+	ScanIndexes(rid string, groups [][]string, min, max, number int64) (int64, []SubItem)
+	CountIndexes(groups [][]string, min, max int64) int64
+}
+
+// ChannelBackend 监控频道的登记及处理到的位置(PTS)
+type ChannelBackend interface {
+	SaveChannel(c *ChannelConf) error
+	GetChannels() []ChannelConf
+	GetChannelPts(chanid int64) int
+	SetChannelPts(chanid int64, pts int) error
+}
+
+// DeliveryBackend 待重试的webhook推送
+type DeliveryBackend interface {
+	SaveDelivery(d *WebhookDelivery) error
+	DueDeliveries(now, count int64) []WebhookDelivery
+	DelDelivery(id string) error
+}
+
+// RelayBackend 已转发消息在目标频道中的msgid
+type RelayBackend interface {
+	GetRelayed(target int64, member string) int
+	SetRelayed(target int64, member string, msgid int) error
+}
+
+// DiscoveryBackend 发现的候选频道
+type DiscoveryBackend interface {
+	GetCandidate(key string) (Candidate, bool)
+	SaveCandidate(c *Candidate) error
+	GetCandidates() []Candidate
+}
 
+var backend Backend
+
+// StoreInit 根据url的scheme选择后端：
+//
+//	redis://127.0.0.1:6379/0
+//	bolt://./data/tgfreesub.db
 func StoreInit(url string) error {
 	var err error
-	rds, err = redis.InitRedis(url)
+	scheme, path, _ := strings.Cut(url, "://")
+	switch scheme {
+	case "bolt", "file":
+		backend, err = newBoltBackend(path)
+	default:
+		backend, err = newRedisBackend(url)
+	}
 	if err != nil {
-		logs.Panic(err).Str("url", url).Msg("SubsRedisInit fail")
+		logs.Panic(err).Str("url", url).Msg("StoreInit fail")
 		return err
 	}
+	logs.Info().Str("url", url).Msgf("store backend: %T", backend)
 	return nil
 }
 
+func StoreClose() error {
+	if backend == nil {
+		return nil
+	}
+	return backend.Close()
+}
+
+// 存储中保存的是频道名，输出时加上 t.me/ 前缀
+func (item *SubItem) addUrlPrefix() {
+	item.ChannelUrl = "t.me/" + strings.TrimPrefix(item.ChannelUrl, "t.me/")
+	item.Sources = slices.Clone(item.Sources)
+	for j := range item.Sources {
+		item.Sources[j].ChannelUrl = "t.me/" + strings.TrimPrefix(item.Sources[j].ChannelUrl, "t.me/")
+	}
+}
+
+// Public 返回与查询结果格式一致的副本，用于推送刚入库的消息
+func (item *SubItem) Public() *SubItem {
+	cp := *item
+	cp.addUrlPrefix()
+	return &cp
+}
+
 func (item *SubItem) calcScore() int64 {
 	// 相对于date -d '2024-1-1 0:0:0' +%s 做偏移
 	return (((item.PubDate - 1704038400) << 31) | item.Msgid)
 }
 
+// Member 消息在存储中的唯一标识：频道名_msgid
+func (item *SubItem) Member() string {
+	return fmt.Sprintf("%s_%d", strings.TrimPrefix(item.ChannelUrl, "t.me/"), item.Msgid)
+}
+
+// 查重与入库需要原子进行
+var addItemMu sync.Mutex
+
+// AddItem 保存消息；其他频道已发布过相同内容(内容指纹与正文链接集合指纹都相同)时，
+// 不再单独保存，只把来源追加到最早保存的那条消息上，并返回 ErrItemMerged；
+// 同一频道重复发布的消息照常保存
 func AddItem(rid string, item *SubItem) error {
-	// score := time.Now().UnixMicro() - socreStartOffset
-	score := item.calcScore()
-	member := fmt.Sprintf("%s_%d", item.ChannelUrl, item.Msgid)
-	rKey := subsItemKeyPrefix + member
+	text := item.MsgContent
+	item.ContentFp = contentFingerprint(text)
+	item.LinkFp = linkFingerprint(extract.Parse(text))
+	item.MsgContent = renderContent(item.MsgContent, item.Entities)
+	fp := dupFingerprint(item.ContentFp, item.LinkFp)
 
-	if rds.ZsetIsMember(subsIndexKey, member) {
-		logs.Trace().Rid(rid).Str("subsIndexKey", subsIndexKey).Str("member", member).Msg("had recored")
-		return nil
+	addItemMu.Lock()
+	defer addItemMu.Unlock()
+
+	member := item.Member()
+	src := item.source()
+	if fp != "" {
+		canon := backend.GetFingerprint(fp)
+		if canon != "" && canon != member && memberChannel(canon) != src.ChannelUrl {
+			if err := backend.AddItemSource(rid, canon, src); err != nil {
+				return err
+			}
+			logs.Info().Rid(rid).Str("member", member).Str("canon", canon).Str("fp", fp).Msg("item merged")
+			return ErrItemMerged
+		}
 	}
 
-	item.MsgContent = strings.ReplaceAll(item.MsgContent, "\n", "</ p>")
-	if err := rds.HashSetAll(rKey, item); err != nil {
-		logs.Warn(err).Rid(rid).Str("rkey", rKey).Msg("HashSetAll fail")
+	item.Sources = SourceList{src}
+	item.FirstSeen = item.PubDate
+	item.Seq = nextSeq()
+	if err := backend.AddItem(rid, item); err != nil {
 		return err
 	}
-
-	logs.Info().Rid(rid).Str("subsIndexKey", subsIndexKey).Str("member", member).Int64("score", score).Msg("add new record")
-	return rds.ZsetAddMember(subsIndexKey, float64(score), member)
+	if err := backend.IndexItem(rid, member, item.calcScore(), itemIndexes(item, text)); err != nil {
+		logs.Warn(err).Rid(rid).Str("member", member).Msg("IndexItem fail")
+	}
+	if err := backend.IndexItem(rid, member, item.Seq, []string{seqIndex}); err != nil {
+		logs.Warn(err).Rid(rid).Str("member", member).Msg("IndexItem seq fail")
+	}
+	if fp != "" && backend.GetFingerprint(fp) == "" {
+		if err := backend.SetFingerprint(fp, member); err != nil {
+			logs.Warn(err).Rid(rid).Str("member", member).Str("fp", fp).Msg("SetFingerprint fail")
+		}
+	}
+	return nil
 }
 
-func GetItemsTotal(_ string) int64 {
-	return rds.ZsetCard(subsIndexKey)
+var lastSeq int64
+
+// nextSeq 入库序号，取当前时间(微秒)，进程内保证递增；调用方持有 addItemMu
+func nextSeq() int64 {
+	lastSeq = max(lastSeq+1, time.Now().UnixMicro())
+	return lastSeq
 }
 
-func QuerySubItems(rid string, cursor, number int64) (int64, []SubItem) {
+// ItemsAfterSeq 查询入库序号大于seq的消息，按入库顺序从新到旧返回；cursor 为0时从最新开始，语义同 QuerySubItems
+func ItemsAfterSeq(rid string, seq, cursor, number int64) (int64, []SubItem) {
 	if cursor == 0 {
 		cursor = int64(^uint64(0) >> 1)
 	}
-	members := rds.ZsetRangeByScore(subsIndexKey, true, -1, cursor, number)
-	if members == nil {
-		return cursor, nil
+	return backend.ScanIndexes(rid, [][]string{{seqIndex}}, seq+1, cursor, number)
+}
+
+func (item *SubItem) source() ItemSource {
+	return ItemSource{
+		ChannelUrl:  strings.TrimPrefix(item.ChannelUrl, "t.me/"),
+		ChannelName: item.ChannelName,
+		Msgid:       item.Msgid,
+		PubDate:     item.PubDate,
 	}
+}
 
-	items := []SubItem{}
+func GetItemsTotal(rid string) int64 {
+	return backend.GetItemsTotal(rid)
+}
 
-	for _, m := range members {
-		rKey := subsItemKeyPrefix + m
-		item := SubItem{}
+func QuerySubItems(rid string, cursor, number int64) (int64, []SubItem) {
+	if cursor == 0 {
+		cursor = int64(^uint64(0) >> 1)
+	}
+	return backend.QuerySubItems(rid, cursor, number)
+}
 
-		if err := rds.HashGetAll(rKey, &item); err != nil {
-			logs.Warn(err).Rid(rid).Str("rkey", rKey).Msg("HashGetAll fail")
-		} else {
-			logs.Debug().Rid(rid).Str("chan", item.ChannelUrl).Int64("msgid", item.Msgid).Send()
-			item.ChannelUrl = "t.me/" + item.ChannelUrl
-			items = append(items, item)
-		}
+// 查询结果的通用处理，返回下一页的起始位置
+func finishQuery(items []SubItem) (int64, []SubItem) {
+	for i := range items {
+		items[i].addUrlPrefix()
 	}
 
 	var nxt int64 = -1
@@ -95,3 +365,55 @@ func QuerySubItems(rid string, cursor, number int64) (int64, []SubItem) {
 	}
 	return nxt, items
 }
+
+// GetChannelPts 获取频道上次处理到的PTS，没有记录时返回0
+func GetChannelPts(chanid int64) int {
+	return backend.GetChannelPts(chanid)
+}
+
+func SetChannelPts(chanid int64, pts int) error {
+	return backend.SetChannelPts(chanid, pts)
+}
+
+// GetRelayed 获取消息转发到 target 频道后的msgid，没有转发过时返回0
+func GetRelayed(target int64, member string) int {
+	return backend.GetRelayed(target, member)
+}
+
+func SetRelayed(target int64, member string, msgid int) error {
+	return backend.SetRelayed(target, member, msgid)
+}
+
+// SetItemSubs 保存消息中订阅链接的抓取结果
+func SetItemSubs(rid, member string, subs SubFetchList) error {
+	return backend.SetItemSubs(rid, member, subs)
+}
+
+// SetItemStatus 更新消息的订阅状态
+func SetItemStatus(rid, member, status string, checkedAt int64) error {
+	return backend.SetItemStatus(rid, member, status, checkedAt)
+}
+
+// AddCheckRecord 追加一条检测记录，只保留最近 maxCheckRecords 条
+func AddCheckRecord(rid, member string, rec *CheckRecord) error {
+	return backend.AddCheckRecord(rid, member, rec)
+}
+
+// GetCheckRecords 获取检测记录，按时间从新到旧
+func GetCheckRecords(rid, member string) []CheckRecord {
+	return backend.GetCheckRecords(rid, member)
+}
+
+// SaveDelivery 保存或更新待重试的webhook请求
+func SaveDelivery(d *WebhookDelivery) error {
+	return backend.SaveDelivery(d)
+}
+
+// DueDeliveries 获取到期需要重试的webhook请求，按到期时间排序
+func DueDeliveries(now, count int64) []WebhookDelivery {
+	return backend.DueDeliveries(now, count)
+}
+
+func DelDelivery(id string) error {
+	return backend.DelDelivery(id)
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"tgfreesub/internal/logs"
 	"time"
 
@@ -30,6 +31,9 @@ const (
 var (
 	ErrMsgClsUnsupport = errors.New("msgcls unsupport")
 	ErrNoLoginCodeHnd  = errors.New("no login code handle")
+	ErrChannelNotFound = errors.New("channel not found")
+	ErrNotReady        = errors.New("tg client not ready")
+	ErrNotChannel      = errors.New("not a channel")
 )
 
 type SubChannelInfo struct {
@@ -48,23 +52,39 @@ type TgSuber struct {
 	Socks5Proxy         string
 	FirstName, UserName string
 	GetHistoryCnt       int
+	AutoJoin            bool // 自动加入 +邀请码 的私有频道
 
 	client       *telegram.Client
 	getLoginCode TgLoginCodeHnd
+	loadPts      TgPtsLoadHnd
+	savePts      TgPtsSaveHnd
 	mhnds        map[TgMsgClass]TgMsgHnd
+	readyHnds    []TgReadyHnd
 	status       int
+
+	chmu   sync.RWMutex
+	chans  map[int64]SubChannelInfo // 已订阅的频道
+	runs   map[int64]*chanRun       // 每个频道的接收协程
+	runCtx context.Context          // 客户端登陆后的ctx，未登陆时为nil
+	peers  map[int64]tgPeer         // 拉取消息时返回的频道信息，用于查找转发来源的username
 }
 
 type TgMsgClass string
 type TgMsgHnd func(int, *TgMsg) error
 type TgLoginCodeHnd func() string
+type TgPtsLoadHnd func(chanid int64) int
+type TgPtsSaveHnd func(chanid int64, pts int) error
+type TgReadyHnd func(ctx context.Context)
 
 type TgMsg struct {
 	From     *SubChannelInfo
 	Date     int64
 	Text     string
+	Entities []TgEntity
+	Buttons  []TgButton
 	FileName string
 	FileSize int64
+	FwdFrom  *TgFwdFrom // 转发自其他频道时不为nil
 
 	ctx   context.Context
 	msg   *tg.Message
@@ -72,6 +92,40 @@ type TgMsg struct {
 	ptype string // for photo
 }
 
+// TgFwdFrom 转发消息的来源频道
+type TgFwdFrom struct {
+	ChannelID int64
+	Name      string // username，未知或私有频道时为空
+	Title     string
+	Msgid     int
+}
+
+type TgEntityClass string
+
+// TgEntity 消息文本中的格式实体，Offset/Length 以 UTF-16 code unit 计算
+type TgEntity struct {
+	Type   TgEntityClass
+	Offset int
+	Length int
+	Url    string // for text_url
+	Lang   string // for pre
+}
+
+// TgButton 消息下方 inline keyboard 中的链接按钮
+type TgButton struct {
+	Text string
+	Url  string
+}
+
+const (
+	TgEntityUrl     TgEntityClass = "url"
+	TgEntityTextUrl TgEntityClass = "text_url"
+	TgEntityCode    TgEntityClass = "code"
+	TgEntityPre     TgEntityClass = "pre"
+	TgEntitySpoiler TgEntityClass = "spoiler"
+	TgEntityMention TgEntityClass = "mention"
+)
+
 const (
 	TgVideo    TgMsgClass = "vedio"
 	TgAudio    TgMsgClass = "music"
@@ -86,6 +140,9 @@ func NewTG(appid int, apphash, phone string) *TgSuber {
 		AppHash: apphash,
 		Phone:   phone,
 		mhnds:   map[TgMsgClass]TgMsgHnd{},
+		chans:   map[int64]SubChannelInfo{},
+		runs:    map[int64]*chanRun{},
+		peers:   map[int64]tgPeer{},
 		status:  TgstatusInit,
 	}
 	return ts
@@ -105,19 +162,21 @@ func (ts *TgSuber) WithSocks5Proxy(addr string) *TgSuber {
 	if addr == "" {
 		return ts
 	}
-	if strings.Contains(addr, "://") {
-		u, err := url.Parse(addr)
-		if err != nil {
-			logs.Error(err).Str("url", addr).Msg("parse fail")
-			return ts
-		}
-		ts.Socks5Proxy = u.Host
-		logs.Info().Str("url", addr).Str("addr", ts.Socks5Proxy).Msg("add proxy")
-		return ts
-	}
+	ts.Socks5Proxy = parseSocks5Addr(addr)
+	logs.Info().Str("url", addr).Str("addr", ts.Socks5Proxy).Msg("add proxy")
+	return ts
+}
+
+// WithAutoJoin 订阅未加入的私有频道(+邀请码)时自动加入
+func (ts *TgSuber) WithAutoJoin(on bool) *TgSuber {
+	ts.AutoJoin = on
+	return ts
+}
 
-	ts.Socks5Proxy = addr
-	logs.Info().Str("addr", ts.Socks5Proxy).Msg("add proxy")
+// WithPtsStore 持久化每个频道的PTS，重启后从上次的位置继续拉取消息
+func (ts *TgSuber) WithPtsStore(load TgPtsLoadHnd, save TgPtsSaveHnd) *TgSuber {
+	ts.loadPts = load
+	ts.savePts = save
 	return ts
 }
 
@@ -126,24 +185,43 @@ func (ts *TgSuber) WithMsgHandle(mcls TgMsgClass, hnd TgMsgHnd) *TgSuber {
 	return ts
 }
 
+// WithReadyHandle 登陆成功后在独立协程中调用，ctx 在客户端退出时取消，可用于调用发送消息等接口
+func (ts *TgSuber) WithReadyHandle(hnd TgReadyHnd) *TgSuber {
+	ts.readyHnds = append(ts.readyHnds, hnd)
+	return ts
+}
+
 func (ts *TgSuber) Run(names []string) error {
 	logs.Info().Int("appid", ts.AppID).Str("apphash", ts.AppHash).Str("phone", ts.Phone).Str("socks5", ts.Socks5Proxy).Strs("channel", names).Send()
 
+	ops, err := clientOptions(ts.SessionPath, ts.Socks5Proxy)
+	if err != nil {
+		return err
+	}
+	ts.client = telegram.NewClient(ts.AppID, ts.AppHash, ops)
+
+	return ts.client.Run(context.Background(), func(ctx context.Context) error {
+		return ts.handle(ctx, names)
+	})
+}
+
+// clientOptions 客户端的session及socks5代理配置，用户客户端与bot客户端共用
+func clientOptions(sessionPath, socks5Addr string) (telegram.Options, error) {
 	// zlog, _ := zap.NewDevelopmentConfig().Build()
 
 	ops := telegram.Options{
 		// Logger: zlog,
 	}
 
-	if ts.SessionPath != "" {
-		ops.SessionStorage = &session.FileStorage{Path: ts.SessionPath}
+	if sessionPath != "" {
+		ops.SessionStorage = &session.FileStorage{Path: sessionPath}
 	}
 
-	if ts.Socks5Proxy != "" {
-		socks5, err := proxy.SOCKS5("tcp", ts.Socks5Proxy, nil, proxy.Direct)
+	if socks5Addr != "" {
+		socks5, err := proxy.SOCKS5("tcp", socks5Addr, nil, proxy.Direct)
 		if err != nil {
-			logs.Warn(err).Str("socks5", ts.Socks5Proxy).Msg("create proxy fail")
-			return err
+			logs.Warn(err).Str("socks5", socks5Addr).Msg("create proxy fail")
+			return ops, err
 		}
 
 		var dial dcs.DialFunc
@@ -161,12 +239,20 @@ func (ts *TgSuber) Run(names []string) error {
 		})
 		ops.DialTimeout = 15 * time.Second
 	}
+	return ops, nil
+}
 
-	ts.client = telegram.NewClient(ts.AppID, ts.AppHash, ops)
-
-	return ts.client.Run(context.Background(), func(ctx context.Context) error {
-		return ts.handle(ctx, names)
-	})
+// parseSocks5Addr 支持 socks5://host:port 与 host:port 两种写法
+func parseSocks5Addr(addr string) string {
+	if !strings.Contains(addr, "://") {
+		return addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		logs.Error(err).Str("url", addr).Msg("parse fail")
+		return ""
+	}
+	return u.Host
 }
 
 func (ts *TgSuber) ReplyTo(msg *TgMsg, text string) error {
@@ -184,6 +270,78 @@ func (ts *TgSuber) ReplyTo(msg *TgMsg, text string) error {
 	return err
 }
 
+// Channel 按id查找已订阅的频道
+func (ts *TgSuber) Channel(chanid int64) (SubChannelInfo, bool) {
+	ts.chmu.RLock()
+	defer ts.chmu.RUnlock()
+	sci, ok := ts.chans[chanid]
+	return sci, ok
+}
+
+// ResolveChannel 解析频道名(私有频道为 +邀请码)，需要已加入
+func (ts *TgSuber) ResolveChannel(ctx context.Context, name string) (SubChannelInfo, error) {
+	for _, sci := range ts.getChannels(ctx, []string{name}) {
+		return sci, nil
+	}
+	return SubChannelInfo{}, fmt.Errorf("%w: %s", ErrChannelNotFound, name)
+}
+
+// ForwardMsg 把 from 频道的消息转发到 to 频道，返回转发后的msgid
+func (ts *TgSuber) ForwardMsg(ctx context.Context, to, from *SubChannelInfo, msgid int) (int, error) {
+	randid := rand.Int63()
+	upd, err := ts.client.API().MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
+		FromPeer: &tg.InputPeerChannel{ChannelID: from.ChannelID, AccessHash: from.AccessHash},
+		ToPeer:   &tg.InputPeerChannel{ChannelID: to.ChannelID, AccessHash: to.AccessHash},
+		ID:       []int{msgid},
+		RandomID: []int64{randid},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sentMsgID(upd, randid), nil
+}
+
+// SendMsg 发送文本消息到 to 频道，返回msgid
+func (ts *TgSuber) SendMsg(ctx context.Context, to *SubChannelInfo, text string) (int, error) {
+	randid := rand.Int63()
+	upd, err := ts.client.API().MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:      &tg.InputPeerChannel{ChannelID: to.ChannelID, AccessHash: to.AccessHash},
+		Message:   text,
+		RandomID:  randid,
+		NoWebpage: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sentMsgID(upd, randid), nil
+}
+
+// sentMsgID 从发送结果中取出新消息的id
+func sentMsgID(upd tg.UpdatesClass, randid int64) int {
+	var list []tg.UpdateClass
+	switch u := upd.(type) {
+	case *tg.UpdateShortSentMessage:
+		return u.ID
+	case *tg.Updates:
+		list = u.Updates
+	case *tg.UpdatesCombined:
+		list = u.Updates
+	}
+	for _, u := range list {
+		switch v := u.(type) {
+		case *tg.UpdateMessageID:
+			if v.RandomID == randid {
+				return v.ID
+			}
+		case *tg.UpdateNewChannelMessage:
+			if m, ok := v.Message.(*tg.Message); ok {
+				return m.ID
+			}
+		}
+	}
+	return 0
+}
+
 func (ts *TgSuber) SaveFile(msg *TgMsg, savePath string) error {
 	switch msg.mcls {
 	case TgPhoto:
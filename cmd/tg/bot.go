@@ -0,0 +1,203 @@
+package tg
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"tgfreesub/internal/logs"
+	"unicode/utf8"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/tg"
+)
+
+const maxBotReplyRunes = 4096
+
+// TgBotCmd 收到的一条bot命令
+type TgBotCmd struct {
+	Cmd     string // 不带 / 与 @botname
+	Args    string
+	UserID  int64
+	Private bool // 私聊
+	AtBot   bool // 带了 @botname
+}
+
+// TgBotCmdHnd 处理命令，返回回复的文本，为空时不回复
+type TgBotCmdHnd func(ctx context.Context, cmd *TgBotCmd) string
+
+type tgBotCmd struct {
+	desc string
+	hnd  TgBotCmdHnd
+}
+
+// TgBot 使用bot token登陆的客户端，只处理私聊/群组中的 /命令
+type TgBot struct {
+	AppID       int
+	AppHash     string
+	Token       string
+	SessionPath string
+	Socks5Proxy string
+	UserName    string
+
+	client *telegram.Client
+	cmds   map[string]tgBotCmd
+	order  []string
+	users  []int64 // 允许使用的用户id，为空时不限制
+}
+
+func NewBot(appid int, apphash, token string) *TgBot {
+	return &TgBot{
+		AppID:   appid,
+		AppHash: apphash,
+		Token:   token,
+		cmds:    map[string]tgBotCmd{},
+	}
+}
+
+func (b *TgBot) WithSession(path string) *TgBot {
+	b.SessionPath = path
+	return b
+}
+
+func (b *TgBot) WithSocks5Proxy(addr string) *TgBot {
+	if addr != "" {
+		b.Socks5Proxy = parseSocks5Addr(addr)
+	}
+	return b
+}
+
+// WithAllowUsers 只响应这些用户的命令
+func (b *TgBot) WithAllowUsers(ids []int64) *TgBot {
+	b.users = ids
+	return b
+}
+
+// WithCommand 注册命令，desc 显示在客户端的命令菜单中
+func (b *TgBot) WithCommand(cmd, desc string, hnd TgBotCmdHnd) *TgBot {
+	if _, ok := b.cmds[cmd]; !ok {
+		b.order = append(b.order, cmd)
+	}
+	b.cmds[cmd] = tgBotCmd{desc: desc, hnd: hnd}
+	return b
+}
+
+func (b *TgBot) Run(ctx context.Context) error {
+	ops, err := clientOptions(b.SessionPath, b.Socks5Proxy)
+	if err != nil {
+		return err
+	}
+	dispatcher := tg.NewUpdateDispatcher()
+	ops.UpdateHandler = dispatcher
+	b.client = telegram.NewClient(b.AppID, b.AppHash, ops)
+
+	sender := message.NewSender(b.client.API())
+	dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
+		msg, ok := u.Message.(*tg.Message)
+		if !ok || msg.Out {
+			return nil
+		}
+		cmd := b.parseCmd(msg)
+		if cmd == nil {
+			return nil
+		}
+		reply := b.handle(ctx, cmd)
+		if reply == "" {
+			return nil
+		}
+		if _, err := sender.Answer(e, u).NoWebpage().Text(ctx, reply); err != nil {
+			logs.Warn(err).Str("cmd", cmd.Cmd).Int64("user", cmd.UserID).Msg("bot reply fail")
+		}
+		return nil
+	})
+
+	return b.client.Run(ctx, func(ctx context.Context) error {
+		status, err := b.client.Auth().Status(ctx)
+		if err != nil {
+			logs.Warn(err).Msg("bot auth status fail")
+			return err
+		}
+		if !status.Authorized {
+			if _, err := b.client.Auth().Bot(ctx, b.Token); err != nil {
+				logs.Warn(err).Msg("bot login fail")
+				return err
+			}
+		}
+		self, err := b.client.Self(ctx)
+		if err != nil {
+			logs.Warn(err).Msg("get bot self fail")
+			return err
+		}
+		b.UserName = self.Username
+		logs.Info().Str("username", self.Username).Int64("id", self.ID).Msg("bot ready")
+
+		b.setCommands(ctx)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+}
+
+// setCommands 把已注册的命令设置为bot的命令菜单
+func (b *TgBot) setCommands(ctx context.Context) {
+	cmds := []tg.BotCommand{}
+	for _, name := range b.order {
+		cmds = append(cmds, tg.BotCommand{Command: name, Description: b.cmds[name].desc})
+	}
+	if _, err := b.client.API().BotsSetBotCommands(ctx, &tg.BotsSetBotCommandsRequest{
+		Scope:    &tg.BotCommandScopeDefault{},
+		Commands: cmds,
+	}); err != nil {
+		logs.Warn(err).Msg("set bot commands fail")
+	}
+}
+
+// parseCmd 解析 /cmd@botname args，不是命令或是发给其他bot的命令时返回nil
+func (b *TgBot) parseCmd(msg *tg.Message) *TgBotCmd {
+	text := strings.TrimSpace(msg.Message)
+	if !strings.HasPrefix(text, "/") {
+		return nil
+	}
+	name, args, _ := strings.Cut(text[1:], " ")
+	name, bot, _ := strings.Cut(name, "@")
+	if bot != "" && !strings.EqualFold(bot, b.UserName) {
+		return nil
+	}
+	cmd := &TgBotCmd{Cmd: strings.ToLower(name), Args: strings.TrimSpace(args), AtBot: bot != ""}
+	_, cmd.Private = msg.PeerID.(*tg.PeerUser)
+	if from, ok := msg.FromID.(*tg.PeerUser); ok {
+		cmd.UserID = from.UserID
+	} else if peer, ok := msg.PeerID.(*tg.PeerUser); ok { // 私聊消息没有FromID
+		cmd.UserID = peer.UserID
+	}
+	return cmd
+}
+
+func (b *TgBot) handle(ctx context.Context, cmd *TgBotCmd) string {
+	if len(b.users) > 0 && !slices.Contains(b.users, cmd.UserID) {
+		logs.Info().Str("cmd", cmd.Cmd).Int64("user", cmd.UserID).Msg("bot user not allowed")
+		return ""
+	}
+	c, ok := b.cmds[cmd.Cmd]
+	if !ok {
+		// 群组中不带 @botname 的命令可能是发给其他bot的，不回复
+		if cmd.Private || cmd.AtBot {
+			return b.help()
+		}
+		return ""
+	}
+	logs.Info().Str("cmd", cmd.Cmd).Str("args", cmd.Args).Int64("user", cmd.UserID).Msg("bot cmd")
+
+	reply := c.hnd(ctx, cmd)
+	if utf8.RuneCountInString(reply) > maxBotReplyRunes {
+		reply = string([]rune(reply)[:maxBotReplyRunes-1]) + "…"
+	}
+	return reply
+}
+
+func (b *TgBot) help() string {
+	lines := []string{}
+	for _, name := range b.order {
+		lines = append(lines, "/"+name+" "+b.cmds[name].desc)
+	}
+	return strings.Join(lines, "\n")
+}
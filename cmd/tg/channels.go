@@ -0,0 +1,99 @@
+package tg
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"tgfreesub/internal/logs"
+)
+
+type chanRun struct {
+	cancel context.CancelFunc
+}
+
+// startChannel 启动频道的接收协程，已在运行时忽略
+func (ts *TgSuber) startChannel(ctx context.Context, sci SubChannelInfo) {
+	ts.chmu.Lock()
+	defer ts.chmu.Unlock()
+	if _, ok := ts.runs[sci.ChannelID]; ok {
+		return
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	run := &chanRun{cancel: cancel}
+	ts.chans[sci.ChannelID] = sci
+	ts.runs[sci.ChannelID] = run
+
+	go func() {
+		defer ts.stopChannel(sci.ChannelID, run)
+		// 有保存的PTS时会从该位置补齐消息，不需要再拉取历史消息
+		if ts.GetHistoryCnt > 0 && !ts.hasSavedPts(sci.ChannelID) {
+			ts.recvChannelHistoryMsg(cctx, &sci, ts.GetHistoryCnt)
+		}
+		ts.recvChannelDiffMsg(cctx, &sci)
+		logs.Info().Str("channel", sci.Name).Str("title", sci.Title).Msg("channel stopped")
+	}()
+}
+
+func (ts *TgSuber) hasSavedPts(chanid int64) bool {
+	return ts.loadPts != nil && ts.loadPts(chanid) > 0
+}
+
+// stopChannel 结束频道的接收协程；run 不为nil时只在仍是该协程时才清理
+func (ts *TgSuber) stopChannel(chanid int64, run *chanRun) {
+	ts.chmu.Lock()
+	defer ts.chmu.Unlock()
+	cur, ok := ts.runs[chanid]
+	if !ok || (run != nil && cur != run) {
+		return
+	}
+	cur.cancel()
+	delete(ts.runs, chanid)
+	delete(ts.chans, chanid)
+}
+
+// AddChannel 运行时订阅频道(私有频道为 +邀请码)，已订阅时直接返回
+func (ts *TgSuber) AddChannel(name string) (SubChannelInfo, error) {
+	ts.chmu.RLock()
+	ctx := ts.runCtx
+	ts.chmu.RUnlock()
+	if ctx == nil || ctx.Err() != nil {
+		return SubChannelInfo{}, ErrNotReady
+	}
+
+	sci, err := ts.ResolveChannel(ctx, name)
+	if err != nil {
+		return sci, err
+	}
+	ts.startChannel(ctx, sci)
+	logs.Info().Str("name", name).Int64("id", sci.ChannelID).Str("title", sci.Title).Msg("channel added")
+	return sci, nil
+}
+
+// RemoveChannel 停止接收频道的消息，返回是否在运行
+func (ts *TgSuber) RemoveChannel(name string) bool {
+	for _, sci := range ts.Channels() {
+		if sci.matchName(name) {
+			ts.stopChannel(sci.ChannelID, nil)
+			logs.Info().Str("name", name).Int64("id", sci.ChannelID).Str("title", sci.Title).Msg("channel removed")
+			return true
+		}
+	}
+	return false
+}
+
+// Channels 正在接收消息的频道
+func (ts *TgSuber) Channels() []SubChannelInfo {
+	ts.chmu.RLock()
+	defer ts.chmu.RUnlock()
+	res := make([]SubChannelInfo, 0, len(ts.chans))
+	for _, sci := range ts.chans {
+		res = append(res, sci)
+	}
+	slices.SortFunc(res, func(a, b SubChannelInfo) int { return strings.Compare(a.Name, b.Name) })
+	return res
+}
+
+// matchName 频道名不区分大小写，私有频道的Name为去掉+的邀请码
+func (sci *SubChannelInfo) matchName(name string) bool {
+	return strings.EqualFold(sci.Name, strings.TrimPrefix(name, "+"))
+}
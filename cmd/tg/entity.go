@@ -0,0 +1,106 @@
+package tg
+
+import "github.com/gotd/td/tg"
+
+// 只保留需要展示/提取链接的实体，加粗、斜体等纯样式实体忽略
+func convEntities(ents []tg.MessageEntityClass) []TgEntity {
+	res := []TgEntity{}
+	for _, ent := range ents {
+		switch e := ent.(type) {
+		case *tg.MessageEntityTextURL:
+			res = append(res, TgEntity{Type: TgEntityTextUrl, Offset: e.Offset, Length: e.Length, Url: e.URL})
+		case *tg.MessageEntityURL:
+			res = append(res, TgEntity{Type: TgEntityUrl, Offset: e.Offset, Length: e.Length})
+		case *tg.MessageEntityCode:
+			res = append(res, TgEntity{Type: TgEntityCode, Offset: e.Offset, Length: e.Length})
+		case *tg.MessageEntityPre:
+			res = append(res, TgEntity{Type: TgEntityPre, Offset: e.Offset, Length: e.Length, Lang: e.Language})
+		case *tg.MessageEntitySpoiler:
+			res = append(res, TgEntity{Type: TgEntitySpoiler, Offset: e.Offset, Length: e.Length})
+		case *tg.MessageEntityMention:
+			res = append(res, TgEntity{Type: TgEntityMention, Offset: e.Offset, Length: e.Length})
+		}
+	}
+	return res
+}
+
+// TextUrls 返回隐藏在文字中的超链接
+func (msg *TgMsg) TextUrls() []string {
+	urls := []string{}
+	for _, e := range msg.Entities {
+		if e.Type == TgEntityTextUrl && e.Url != "" {
+			urls = append(urls, e.Url)
+		}
+	}
+	return urls
+}
+
+// 只取带链接的按钮，回调类按钮对我们没有意义
+func convButtons(markup tg.ReplyMarkupClass) []TgButton {
+	res := []TgButton{}
+	inline, ok := markup.(*tg.ReplyInlineMarkup)
+	if !ok {
+		return res
+	}
+	for _, row := range inline.Rows {
+		for _, btn := range row.Buttons {
+			switch b := btn.(type) {
+			case *tg.KeyboardButtonURL:
+				res = append(res, TgButton{Text: b.Text, Url: b.URL})
+			case *tg.KeyboardButtonURLAuth:
+				res = append(res, TgButton{Text: b.Text, Url: b.URL})
+			}
+		}
+	}
+	return res
+}
+
+// ButtonUrls 返回按钮中的链接
+func (msg *TgMsg) ButtonUrls() []string {
+	urls := []string{}
+	for _, b := range msg.Buttons {
+		urls = append(urls, b.Url)
+	}
+	return urls
+}
+
+const maxPeerCache = 4096
+
+type tgPeer struct {
+	name  string
+	title string
+}
+
+// rememberChats 缓存拉取消息时一起返回的频道信息
+func (ts *TgSuber) rememberChats(chats []tg.ChatClass) {
+	ts.chmu.Lock()
+	defer ts.chmu.Unlock()
+	if len(ts.peers) > maxPeerCache {
+		clear(ts.peers)
+	}
+	for _, c := range chats {
+		if ch, ok := c.(*tg.Channel); ok {
+			ts.peers[ch.ID] = tgPeer{name: ch.Username, title: ch.Title}
+		}
+	}
+}
+
+// convFwdFrom 只关心转发自频道的消息
+func (ts *TgSuber) convFwdFrom(msg *tg.Message) *TgFwdFrom {
+	fwd, ok := msg.GetFwdFrom()
+	if !ok {
+		return nil
+	}
+	pc, ok := fwd.FromID.(*tg.PeerChannel)
+	if !ok {
+		return nil
+	}
+	ff := &TgFwdFrom{ChannelID: pc.ChannelID, Title: fwd.FromName, Msgid: fwd.ChannelPost}
+
+	ts.chmu.RLock()
+	if p, ok := ts.peers[pc.ChannelID]; ok {
+		ff.Name, ff.Title = p.name, p.title
+	}
+	ts.chmu.RUnlock()
+	return ff
+}
@@ -5,12 +5,17 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"sync"
 	"tgfreesub/internal/logs"
 	"time"
 
 	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+const (
+	maxJoinRetry     = 3
+	maxJoinFloodWait = 5 * time.Minute // 等待时间更长时放弃，下次启动再加入
 )
 
 func (ts *TgSuber) handle(ctx context.Context, names []string) error {
@@ -36,25 +41,23 @@ func (ts *TgSuber) handle(ctx context.Context, names []string) error {
 		Bool("bot", self.Bot).Str("phone", ts.Phone).
 		Int("appid", ts.AppID).Msg("ready")
 
+	for _, hnd := range ts.readyHnds {
+		go hnd(ctx)
+	}
+
+	ts.chmu.Lock()
+	ts.runCtx = ctx
+	ts.chmu.Unlock()
+
 	cs := ts.getChannels(ctx, names)
 	if len(cs) == 0 {
-		logs.Error(nil).Msg("no channels need subscribe")
-		return nil
+		logs.Warn(nil).Msg("no channels need subscribe, wait for adding at runtime")
 	}
-
-	wg := sync.WaitGroup{}
-	wg.Add(len(cs))
 	for _, sci := range cs {
-		go func() {
-			if ts.GetHistoryCnt > 0 {
-				ts.recvChannelHistoryMsg(ctx, &sci)
-			}
-			ts.recvChannelDiffMsg(ctx, &sci)
-			wg.Done()
-		}()
+		ts.startChannel(ctx, sci)
 	}
 
-	wg.Wait()
+	<-ctx.Done()
 	return nil
 }
 
@@ -131,8 +134,21 @@ func (ts *TgSuber) getChannels(ctx context.Context, names []string) map[int64]Su
 					logs.Info().Str("name", name).Int64("id", sci.ChannelID).Int64("hash", sci.AccessHash).Str("title", sci.Title).Msg("private")
 				}
 			case *tg.ChatInvite: // 未加入，需要调用 MessagesImportChatInvite 加入
-				// joined, _ := api.MessagesImportChatInvite(ctx, name)
-				logs.Warn(nil).Str("name", name).Msg("not in channel")
+				if !ts.AutoJoin {
+					logs.Warn(nil).Str("name", name).Msg("not in channel")
+					continue
+				}
+				if sci, ok := ts.joinInvite(ctx, name, inv); ok {
+					cs[sci.ChannelID] = sci
+				}
+			case *tg.ChatInvitePeek: // 可以预览但未加入
+				if !ts.AutoJoin {
+					logs.Warn(nil).Str("name", name).Msg("not in channel")
+					continue
+				}
+				if sci, ok := ts.joinInvite(ctx, name, nil); ok {
+					cs[sci.ChannelID] = sci
+				}
 			}
 		} else {
 			res, err := api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{
@@ -143,6 +159,11 @@ func (ts *TgSuber) getChannels(ctx context.Context, names []string) map[int64]Su
 				continue
 			}
 
+			// 用户名可能属于用户或bot，此时没有Chats
+			if len(res.Chats) == 0 {
+				logs.Warn(ErrNotChannel).Str("channel.name", name).Msg("resolve fail")
+				continue
+			}
 			if ch, ok := res.Chats[0].(*tg.Channel); ok {
 				sci := SubChannelInfo{
 					Name:       ch.Username,
@@ -153,13 +174,84 @@ func (ts *TgSuber) getChannels(ctx context.Context, names []string) map[int64]Su
 
 				cs[ch.ID] = sci
 				logs.Info().Str("name", name).Int64("id", sci.ChannelID).Int64("hash", sci.AccessHash).Str("title", sci.Title).Msg("public")
+			} else {
+				logs.Warn(ErrNotChannel).Str("channel.name", name).Msgf("resolve to %T", res.Chats[0])
 			}
 		}
 	}
 	return cs
 }
 
-func (ts *TgSuber) recvChannelHistoryMsg(ctx context.Context, sci *SubChannelInfo) {
+// joinInvite 通过邀请链接加入私有频道；需要管理员审批的邀请只发送申请，审批通过后重启或通过管理接口恢复即可
+func (ts *TgSuber) joinInvite(ctx context.Context, hash string, inv *tg.ChatInvite) (SubChannelInfo, bool) {
+	if inv != nil && !inv.Channel {
+		logs.Warn(nil).Str("name", hash).Str("title", inv.Title).Msg("invite is not a channel, skip")
+		return SubChannelInfo{}, false
+	}
+
+	api := ts.client.API()
+	for i := 0; ; i++ {
+		upd, err := api.MessagesImportChatInvite(ctx, hash)
+		if err == nil {
+			if sci, ok := joinedChannel(upd, hash); ok {
+				logs.Info().Str("name", hash).Int64("id", sci.ChannelID).Str("title", sci.Title).Msg("joined")
+				return sci, true
+			}
+			logs.Warn(nil).Str("name", hash).Msg("joined but no channel in updates")
+			return SubChannelInfo{}, false
+		}
+
+		switch {
+		case tgerr.Is(err, "INVITE_REQUEST_SENT"):
+			logs.Info().Str("name", hash).Msg("join request sent, wait for approval")
+			return SubChannelInfo{}, false
+		case tgerr.Is(err, "USER_ALREADY_PARTICIPANT"):
+			// 检查邀请时还未加入，之后已加入(如审批通过)，重新获取频道信息
+			if invite, err := api.MessagesCheckChatInvite(ctx, hash); err == nil {
+				if already, ok := invite.(*tg.ChatInviteAlready); ok {
+					if ch, ok := already.Chat.(*tg.Channel); ok {
+						return SubChannelInfo{Name: hash, Title: ch.Title, ChannelID: ch.ID, AccessHash: ch.AccessHash}, true
+					}
+				}
+			}
+			return SubChannelInfo{}, false
+		case tgerr.Is(err, "INVITE_HASH_EXPIRED", "INVITE_HASH_INVALID", "CHANNELS_TOO_MUCH"):
+			logs.Warn(err).Str("name", hash).Msg("join fail")
+			return SubChannelInfo{}, false
+		}
+
+		d, ok := tgerr.AsFloodWait(err)
+		if !ok || d > maxJoinFloodWait || i >= maxJoinRetry {
+			logs.Warn(err).Str("name", hash).Dur("wait", d).Msg("join fail")
+			return SubChannelInfo{}, false
+		}
+		logs.Info().Str("name", hash).Dur("wait", d).Msg("join flood wait")
+		select {
+		case <-ctx.Done():
+			return SubChannelInfo{}, false
+		case <-time.After(d + time.Second):
+		}
+	}
+}
+
+// joinedChannel 从加入结果中取出频道
+func joinedChannel(upd tg.UpdatesClass, hash string) (SubChannelInfo, bool) {
+	var chats []tg.ChatClass
+	switch u := upd.(type) {
+	case *tg.Updates:
+		chats = u.Chats
+	case *tg.UpdatesCombined:
+		chats = u.Chats
+	}
+	for _, c := range chats {
+		if ch, ok := c.(*tg.Channel); ok {
+			return SubChannelInfo{Name: hash, Title: ch.Title, ChannelID: ch.ID, AccessHash: ch.AccessHash}, true
+		}
+	}
+	return SubChannelInfo{}, false
+}
+
+func (ts *TgSuber) recvChannelHistoryMsg(ctx context.Context, sci *SubChannelInfo, limit int) {
 	api := ts.client.API()
 
 	peer := &tg.InputPeerChannel{
@@ -179,7 +271,7 @@ func (ts *TgSuber) recvChannelHistoryMsg(ctx context.Context, sci *SubChannelInf
 
 	history, err := api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
 		Peer:  peer,
-		Limit: ts.GetHistoryCnt,
+		Limit: limit,
 	})
 	if err != nil {
 		logs.Warn(err).Str("channel", sci.Name).Str("title", sci.Title).Msg("MessagesGetHistory fail")
@@ -192,6 +284,7 @@ func (ts *TgSuber) recvChannelHistoryMsg(ctx context.Context, sci *SubChannelInf
 	}
 
 	logs.Debug().Int("msgs.Messages.size", len(msgs.Messages))
+	ts.rememberChats(msgs.Chats)
 	for _, m := range msgs.Messages {
 		if msg, ok := m.(*tg.Message); ok {
 			ts.recvChannelMsgHandle(ctx, msg, sci)
@@ -200,67 +293,121 @@ func (ts *TgSuber) recvChannelHistoryMsg(ctx context.Context, sci *SubChannelInf
 }
 
 func (ts *TgSuber) recvChannelDiffMsg(ctx context.Context, sci *SubChannelInfo) {
-	api := ts.client.API()
-
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	peer := &tg.InputPeerChannel{
-		ChannelID:  sci.ChannelID,
-		AccessHash: sci.AccessHash,
-	}
-
 	// 建立上下文（必须要调一次，不然不会推送消息）
-	full, err := api.ChannelsGetFullChannel(ctx, &tg.InputChannel{
-		ChannelID:  peer.ChannelID,
-		AccessHash: peer.AccessHash,
-	})
+	fullPts, err := ts.fullChannelPts(ctx, sci)
 	if err != nil {
-		logs.Error(err).Str("channel", sci.Name).Str("title", sci.Title).Msg("ChannelsGetFullChannel fail")
 		return
 	}
 
-	chatFull, ok := full.FullChat.(*tg.ChannelFull)
-	if !ok {
-		logs.Error(err).Str("channel", sci.Name).Str("title", sci.Title).Msg("FullChat fail")
-		return
+	sci.Pts = 0
+	if ts.loadPts != nil {
+		if pts := ts.loadPts(sci.ChannelID); pts > 0 && pts < fullPts {
+			// 从上次处理的位置继续，补齐停机期间的消息
+			logs.Info().Str("channel", sci.Name).Int("saved.pts", pts).Int("pts", fullPts).Msg("resume")
+			sci.Pts = pts
+		}
+	}
+	if sci.Pts == 0 {
+		ts.updatePts(sci, fullPts)
 	}
-
-	pts := chatFull.Pts
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			diff, err := api.UpdatesGetChannelDifference(ctx, &tg.UpdatesGetChannelDifferenceRequest{
-				Channel: &tg.InputChannel{
-					ChannelID:  peer.ChannelID,
-					AccessHash: peer.AccessHash,
-				},
-				Filter: &tg.ChannelMessagesFilterEmpty{},
-				Pts:    pts,
-				Limit:  50,
-			})
-			if err != nil {
-				logs.Warn(err).Str("channel", sci.Name).Str("title", sci.Title).Msg("UpdatesGetChannelDifference fail")
-				continue
-			}
+			ts.pullChannelDiff(ctx, sci)
+		}
+	}
+}
 
-			switch upd := diff.(type) {
-			case *tg.UpdatesChannelDifference:
-				for _, m := range upd.NewMessages {
-					if msg, ok := m.(*tg.Message); ok {
-						ts.recvChannelMsgHandle(ctx, msg, sci)
-					}
+// pullChannelDiff 拉取频道从 sci.Pts 开始的所有新消息，直到 final
+func (ts *TgSuber) pullChannelDiff(ctx context.Context, sci *SubChannelInfo) {
+	api := ts.client.API()
+
+	for {
+		diff, err := api.UpdatesGetChannelDifference(ctx, &tg.UpdatesGetChannelDifferenceRequest{
+			Channel: &tg.InputChannel{
+				ChannelID:  sci.ChannelID,
+				AccessHash: sci.AccessHash,
+			},
+			Filter: &tg.ChannelMessagesFilterEmpty{},
+			Pts:    sci.Pts,
+			Limit:  50,
+		})
+		if err != nil {
+			logs.Warn(err).Str("channel", sci.Name).Str("title", sci.Title).Msg("UpdatesGetChannelDifference fail")
+			return
+		}
+
+		final := true
+		switch upd := diff.(type) {
+		case *tg.UpdatesChannelDifference:
+			ts.rememberChats(upd.Chats)
+			for _, m := range upd.NewMessages {
+				if msg, ok := m.(*tg.Message); ok {
+					ts.recvChannelMsgHandle(ctx, msg, sci)
 				}
-				// 更新 PTS
-				pts = upd.Pts
-			case *tg.UpdatesChannelDifferenceEmpty:
-				// 没有新消息，更新 PTS
-				pts = upd.Pts
+			}
+			// 更新 PTS
+			ts.updatePts(sci, upd.Pts)
+			final = upd.Final
+		case *tg.UpdatesChannelDifferenceEmpty:
+			// 没有新消息，更新 PTS
+			ts.updatePts(sci, upd.Pts)
+		case *tg.UpdatesChannelDifferenceTooLong:
+			// 落后太多，服务端不再给差量，改为拉取历史消息，然后从最新的PTS继续
+			logs.Warn(nil).Str("channel", sci.Name).Int("pts", sci.Pts).Msg("channel difference too long")
+			ts.recvChannelHistoryMsg(ctx, sci, max(ts.GetHistoryCnt, 100))
+			pts := 0
+			if dlg, ok := upd.Dialog.(*tg.Dialog); ok {
+				pts, _ = dlg.GetPts()
+			}
+			if pts == 0 { // Dialog中没有PTS时取频道当前的PTS，否则下次还会TooLong
+				pts, _ = ts.fullChannelPts(ctx, sci)
+			}
+			if pts > 0 {
+				ts.updatePts(sci, pts)
 			}
 		}
+
+		if final {
+			return
+		}
+	}
+}
+
+// fullChannelPts 获取频道当前的PTS
+func (ts *TgSuber) fullChannelPts(ctx context.Context, sci *SubChannelInfo) (int, error) {
+	full, err := ts.client.API().ChannelsGetFullChannel(ctx, &tg.InputChannel{
+		ChannelID:  sci.ChannelID,
+		AccessHash: sci.AccessHash,
+	})
+	if err != nil {
+		logs.Error(err).Str("channel", sci.Name).Str("title", sci.Title).Msg("ChannelsGetFullChannel fail")
+		return 0, err
+	}
+	chatFull, ok := full.FullChat.(*tg.ChannelFull)
+	if !ok {
+		logs.Error(nil).Str("channel", sci.Name).Str("title", sci.Title).Msg("FullChat fail")
+		return 0, ErrNotChannel
+	}
+	return chatFull.Pts, nil
+}
+
+func (ts *TgSuber) updatePts(sci *SubChannelInfo, pts int) {
+	if pts == sci.Pts {
+		return
+	}
+	sci.Pts = pts
+	if ts.savePts == nil {
+		return
+	}
+	if err := ts.savePts(sci.ChannelID, pts); err != nil {
+		logs.Warn(err).Str("channel", sci.Name).Int("pts", pts).Msg("save pts fail")
 	}
 }
 
@@ -296,9 +443,12 @@ func (ts *TgSuber) recvChannelNoteMsg(ctx context.Context, msg *tg.Message, sci
 	}
 
 	tgmsg := TgMsg{
-		From: sci,
-		Text: msg.Message,
-		Date: int64(msg.Date),
+		From:     sci,
+		Text:     msg.Message,
+		Entities: convEntities(msg.Entities),
+		Buttons:  convButtons(msg.ReplyMarkup),
+		FwdFrom:  ts.convFwdFrom(msg),
+		Date:     int64(msg.Date),
 
 		mcls: TgNote,
 		msg:  msg,
@@ -344,6 +494,9 @@ func (ts *TgSuber) recvChannelPhotoMsg(ctx context.Context, msg *tg.Message, sci
 	tgmsg := TgMsg{
 		From:     sci,
 		Text:     msg.Message,
+		Entities: convEntities(msg.Entities),
+		Buttons:  convButtons(msg.ReplyMarkup),
+		FwdFrom:  ts.convFwdFrom(msg),
 		FileName: fmt.Sprintf("%s_%d.jpg", sci.Name, photo.Date),
 		FileSize: int64(maxSize),
 		Date:     int64(msg.Date),
@@ -384,6 +537,9 @@ func (ts *TgSuber) recvChannelMediaMsg(ctx context.Context, msg *tg.Message, sci
 	tgmsg := TgMsg{
 		From:     sci,
 		Text:     msg.Message,
+		Entities: convEntities(msg.Entities),
+		Buttons:  convButtons(msg.ReplyMarkup),
+		FwdFrom:  ts.convFwdFrom(msg),
 		FileSize: int64(doc.GetSize()),
 		Date:     int64(msg.Date),
 
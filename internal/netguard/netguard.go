@@ -0,0 +1,49 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// ErrForbiddenAddr 内网、回环等地址，来自频道消息的地址不允许连接
+var ErrForbiddenAddr = errors.New("forbidden address")
+
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// Forbidden 回环、私有、链路本地、未指定、组播及CGNAT地址
+func Forbidden(a netip.Addr) bool {
+	a = a.Unmap()
+	return !a.IsValid() || a.IsLoopback() || a.IsPrivate() || a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() ||
+		a.IsUnspecified() || a.IsMulticast() || cgnatPrefix.Contains(a)
+}
+
+// Control 用作 net.Dialer.Control，建立连接前检查解析后的目标ip
+func Control(network, address string, c syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if Forbidden(ap.Addr()) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddr, address)
+	}
+	return nil
+}
+
+// CheckHost 解析域名，任一ip为禁止的地址时返回错误；用于由代理解析目标地址的场景，
+// 本地解析失败时无法判断代理会连到哪里，也视为禁止
+func CheckHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: %s(%v)", ErrForbiddenAddr, host, err)
+	}
+	for _, a := range addrs {
+		if Forbidden(a) {
+			return fmt.Errorf("%w: %s(%s)", ErrForbiddenAddr, host, a)
+		}
+	}
+	return nil
+}
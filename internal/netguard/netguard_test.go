@@ -0,0 +1,33 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestControl(t *testing.T) {
+	for _, s := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1",
+		"::1", "fe80::1", "fd00::1", "0.0.0.0", "::ffff:127.0.0.1", "224.0.0.1"} {
+		if err := Control("tcp", net.JoinHostPort(s, "80"), nil); !errors.Is(err, ErrForbiddenAddr) {
+			t.Errorf("%s: err = %v", s, err)
+		}
+	}
+	for _, s := range []string{"8.8.8.8", "1.1.1.1", "2001:4860:4860::8888"} {
+		if err := Control("tcp", net.JoinHostPort(s, "443"), nil); err != nil {
+			t.Errorf("%s: err = %v", s, err)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	for _, h := range []string{"127.0.0.1", "localhost", "10.0.0.1", "no-such-host.invalid"} {
+		if err := CheckHost(context.Background(), h); !errors.Is(err, ErrForbiddenAddr) {
+			t.Errorf("%s: err = %v", h, err)
+		}
+	}
+	if err := CheckHost(context.Background(), "8.8.8.8"); err != nil {
+		t.Errorf("public: err = %v", err)
+	}
+}
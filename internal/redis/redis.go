@@ -86,12 +86,53 @@ func (r *RdsClient) ZsetRangeByScore(rKey string, rev bool, min, max, count int6
 
 	return r.ZRangeByScore(ctx, rKey, zrngOpts).Val()
 }
+func (r *RdsClient) ZsetRangeByScoreWithScores(rKey string, rev bool, min, max, count int64) []ZMember {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	zrngOpts := &redis.ZRangeBy{
+		Min:   strconv.FormatInt(min, 10),
+		Max:   "(" + strconv.FormatInt(max, 10), // 开区间
+		Count: count,
+	}
+	var zs []redis.Z
+	if rev {
+		zs = r.ZRevRangeByScoreWithScores(ctx, rKey, zrngOpts).Val()
+	} else {
+		zs = r.ZRangeByScoreWithScores(ctx, rKey, zrngOpts).Val()
+	}
+
+	res := make([]ZMember, 0, len(zs))
+	for _, z := range zs {
+		res = append(res, ZMember(z))
+	}
+	return res
+}
+
+// ZsetAddMemberToKeys 把同一个member加入多个zset
+func (r *RdsClient) ZsetAddMemberToKeys(rKeys []string, score float64, member any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	pipe := (*redis.Client)(r).Pipeline()
+	for _, rKey := range rKeys {
+		pipe.ZAdd(ctx, rKey, redis.Z{Score: score, Member: member})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
 func (r *RdsClient) ZsetAddMember(rKey string, score float64, member any) error {
 	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
 	defer cancel()
 
 	return r.ZAdd(ctx, rKey, redis.Z{Score: score, Member: member}).Err()
 }
+func (r *RdsClient) ZsetDelMember(rKey string, members ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	return r.ZRem(ctx, rKey, members...).Err()
+}
 func (r *RdsClient) ZsetIsMember(rKey string, member string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
 	defer cancel()
@@ -112,6 +153,48 @@ func (r *RdsClient) HashSetAll(rKey string, in any) error {
 
 	return r.HSet(ctx, rKey, in).Err()
 }
+func (r *RdsClient) HashGetAllFields(rKey string) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	return r.HGetAll(ctx, rKey).Val()
+}
+func (r *RdsClient) HashGetField(rKey, field string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	return r.HGet(ctx, rKey, field).Result()
+}
+func (r *RdsClient) HashSetField(rKey, field string, val any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	return r.HSet(ctx, rKey, field, val).Err()
+}
+func (r *RdsClient) HashDelField(rKey string, fields ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	return r.HDel(ctx, rKey, fields...).Err()
+}
+
+// ListPushTrim 头部插入并只保留最新的max条
+func (r *RdsClient) ListPushTrim(rKey string, val any, max int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	pipe := (*redis.Client)(r).TxPipeline()
+	pipe.LPush(ctx, rKey, val)
+	pipe.LTrim(ctx, rKey, 0, max-1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+func (r *RdsClient) ListRange(rKey string, start, stop int64) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
+	defer cancel()
+
+	return r.LRange(ctx, rKey, start, stop).Val()
+}
 
 func (r *RdsClient) CheckKeyExisted(rKey string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), RdsOperateTimeout)
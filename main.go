@@ -2,12 +2,24 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"embed"
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"tgfreesub/cmd/botcmd"
+	"tgfreesub/cmd/checker"
+	"tgfreesub/cmd/discovery"
+	"tgfreesub/cmd/extract"
+	"tgfreesub/cmd/fetcher"
+	"tgfreesub/cmd/filter"
 	"tgfreesub/cmd/httpsrv"
+	"tgfreesub/cmd/hub"
+	"tgfreesub/cmd/notifier"
+	"tgfreesub/cmd/prober"
+	"tgfreesub/cmd/relay"
 	"tgfreesub/cmd/store"
 	"tgfreesub/cmd/tg"
 	"tgfreesub/internal/logs"
@@ -22,6 +34,12 @@ var embeddedStaticFiles embed.FS
 
 var errItemFiltered = errors.New("item filtered")
 
+var itemRules = filter.Default()
+
+var subFetcher *fetcher.Fetcher
+
+var itemHub = hub.NewHub()
+
 func main() {
 	appid := utils.XmArgValInt("appid", "https://core.telegram.org/api/obtaining_api_id", 0)
 	appHash := utils.XmArgValString("apphash", "", "")
@@ -30,30 +48,115 @@ func main() {
 	sessionPath := utils.XmArgValString("session", "session file", "./session.json")
 	getHistoryCnt := utils.XmArgValInt("history", "get history msg count", 0)
 	rdsAddr := utils.XmArgValString("redis", "redis-server addr", "redis://127.0.0.1:6379/0")
+	storeUrl := utils.XmArgValString("store", "store url: redis://... or bolt://./data/tgfreesub.db, default use -redis", "")
 	httpAddr := utils.XmArgValString("server", "http server listen addr", "127.0.0.1:2010")
 	socks5 := utils.XmArgValString("proxy", "proxy url: socks5://127.0.0.1:1080", "")
+	fetchers := utils.XmArgValInt("fetchers", "subscription url fetch workers, 0 to disable", 2)
+	checkMins := utils.XmArgValInt("checkmins", "subscription liveness check interval(minutes), 0 to disable, needs -fetchers > 0", 60)
+	checkDays := utils.XmArgValInt("checkdays", "only check msgs published within days", 7)
+	probes := utils.XmArgValInt("probes", "node latency probe concurrency, 0 to disable", 32)
+	reindex := utils.XmArgValBool("reindex", "rebuild store indexes(channel/link type/search) for saved msgs at startup")
+	webhooksPath := utils.XmArgValString("webhooks", "webhook config file(json), push new msgs to the urls", "")
+	autoJoin := utils.XmArgValBool("autojoin", "auto join private channels(+invite hash) not joined yet")
+	relayTarget := utils.XmArgValString("relay", "relay kept msgs to own channel/group(name or +invite hash)", "")
+	relayMode := utils.XmArgValString("relaymode", "relay mode: forward or repost", relay.ModeForward)
+	relaySecs := utils.XmArgValInt("relaysecs", "min interval(seconds) between relayed msgs", 3)
+	botToken := utils.XmArgValString("bottoken", "bot token from @BotFather, enable bot commands", "")
+	botSession := utils.XmArgValString("botsession", "bot session file", "./bot_session.json")
+	botUsers := utils.XmArgValInt64s("botusers", "user ids allowed to use the bot, default anyone", 0)
+	adminToken := utils.XmArgValString("admintoken", "token of admin api(/admin/...), empty to disable", "")
+	publicUrl := utils.XmArgValString("publicurl", "public url of http server, used in bot replies, default http://<server>", "")
+	rulesPath := utils.XmArgValString("rules", "filter rules file(json), default keep msgs with 机场/订阅/节点", "")
 
 	utils.XmLogsInit("./logs/tgfreesub.log", 0, 50<<20, 1) // 设置日志级别为0(DEBUG)
 
 	utils.XmUsageIfHasKeys("h", "help")
-	utils.XmUsageIfHasNoKeys("appid", "apphash", "names", "phone")
+	utils.XmUsageIfHasNoKeys("appid", "apphash", "phone")
 
-	store.StoreInit(rdsAddr)
+	if rulesPath != "" {
+		rules, err := filter.Load(rulesPath)
+		if err != nil {
+			logs.Panic(err).Str("rules", rulesPath).Msg("load filter rules fail")
+		}
+		itemRules = rules
+	}
 
-	go httpsrv.StartHttpSrv(embeddedStaticFiles, httpAddr)
+	if storeUrl == "" {
+		storeUrl = rdsAddr
+	}
+	store.StoreInit(storeUrl)
+	defer store.StoreClose()
+
+	if reindex {
+		store.ReindexItems(ulid.Make().String())
+	}
+
+	// 检测复用抓取器的http客户端(代理、内网地址限制)，-fetchers 0 表示不访问订阅链接，此时也不检测
+	if fetchers > 0 {
+		subFetcher = fetcher.NewFetcher(socks5)
+		subFetcher.Start(context.Background(), fetchers)
+
+		if checkMins > 0 {
+			checker.NewChecker(subFetcher, time.Duration(checkMins)*time.Minute, time.Duration(checkDays)*24*time.Hour).
+				Start(context.Background())
+		}
+	}
+
+	if probes > 0 {
+		httpsrv.SetNodeProber(prober.NewProber(probes, 5*time.Second))
+	}
+
+	if webhooksPath != "" {
+		hooks, err := notifier.Load(webhooksPath)
+		if err != nil {
+			logs.Panic(err).Str("webhooks", webhooksPath).Msg("load webhooks fail")
+		}
+		notifier.NewNotifier(hooks).Start(context.Background(), itemHub, 2)
+	}
 
 	ts := tg.NewTG(appid, appHash, phone).
 		WithHistoryMsgCnt(getHistoryCnt).
+		WithAutoJoin(autoJoin).
 		WithSocks5Proxy(socks5).
+		WithPtsStore(store.GetChannelPts, store.SetChannelPts).
 		WithSession(sessionPath, inputLoginCode)
 
+	httpsrv.SetItemHub(itemHub)
+	httpsrv.SetChannelRunner(ts)
+	httpsrv.SetAdminToken(adminToken)
+	go httpsrv.StartHttpSrv(embeddedStaticFiles, httpAddr)
+
+	if botToken != "" {
+		if publicUrl == "" {
+			publicUrl = "http://" + httpAddr
+		}
+		bot := tg.NewBot(appid, appHash, botToken).
+			WithSession(botSession).
+			WithSocks5Proxy(socks5).
+			WithAllowUsers(slices.DeleteFunc(botUsers, func(id int64) bool { return id == 0 }))
+		botcmd.Register(bot, publicUrl, store.ActiveChannels)
+		go func() {
+			if err := bot.Run(context.Background()); err != nil {
+				logs.Error(err).Msg("bot exit")
+			}
+		}()
+	}
+
+	if relayTarget != "" {
+		ts.WithReadyHandle(func(ctx context.Context) {
+			relay.NewRelayer(ts, relayTarget, relayMode).
+				WithInterval(time.Duration(relaySecs)*time.Second).
+				Run(ctx, itemHub)
+		})
+	}
+
 	ts.WithMsgHandle(tg.TgNote, func(msgid int, tgmsg *tg.TgMsg) error {
 		sci := tgmsg.From
 		dateStr := time.Unix(tgmsg.Date, 0).Format(time.DateTime)
 		logs.Info().Int("msgid", msgid).Str("content", tgmsg.Text).
 			Str("date", dateStr).Str("channel", sci.Name).
 			Msg(sci.Title)
-		return addNewSubItem(sci.Name, sci.Title, tgmsg.Date, tgmsg.Text, sci.ChannelID, int64(msgid))
+		return addNewSubItem(int64(msgid), tgmsg)
 	})
 
 	ts.WithMsgHandle(tg.TgPhoto, func(msgid int, tgmsg *tg.TgMsg) error {
@@ -66,10 +169,11 @@ func main() {
 		logs.Info().Int("msgid", msgid).Str("content", tgmsg.Text).
 			Str("date", dateStr).Str("channel", sci.Name).
 			Msg(sci.Title)
-		return addNewSubItem(sci.Name, sci.Title, tgmsg.Date, tgmsg.Text, sci.ChannelID, int64(msgid))
+		return addNewSubItem(int64(msgid), tgmsg)
 	})
 
-	ts.Run(names)
+	// -names 中的频道登记到存储，之后以存储中的登记为准，可通过管理接口运行时增删
+	ts.Run(store.SeedChannels(names))
 }
 
 func inputLoginCode() string {
@@ -89,34 +193,71 @@ func inputLoginCode() string {
 	}
 }
 
-func addNewSubItem(url, name string, date int64, content string, chanid, msgid int64) error {
+func addNewSubItem(msgid int64, tgmsg *tg.TgMsg) error {
+	sci := tgmsg.From
+	url := sci.Name
 	item := &store.SubItem{
 		ChannelUrl:  url,
-		ChannelName: name,
-		PubDate:     date,
-		MsgContent:  content,
-		ChannelID:   chanid,
+		ChannelName: sci.Title,
+		PubDate:     tgmsg.Date,
+		MsgContent:  tgmsg.Text,
+		ChannelID:   sci.ChannelID,
 		Msgid:       msgid,
+		Links:       extract.Parse(tgmsg.Text, append(tgmsg.TextUrls(), tgmsg.ButtonUrls()...)...),
+		Buttons:     convButtons(tgmsg.Buttons),
+		Entities:    convEntities(tgmsg.Entities),
 	}
 
-	if itemFilter(item) {
+	keep, reason := itemRules.Match(item)
+
+	// 记录消息中转发/提及的其他频道，用于发现新的频道
+	refs := discovery.ExtractRefs(tgmsg.Text, append(tgmsg.TextUrls(), tgmsg.ButtonUrls()...)...)
+	if ff := tgmsg.FwdFrom; ff != nil {
+		refs = append(refs, discovery.FwdRef(ff.ChannelID, ff.Name, ff.Title))
+	}
+	discovery.Record(url, sci.ChannelID, msgid, refs, keep)
+
+	if !keep {
+		logs.Debug().Int64("msgid", msgid).Str("channel", url).Str("reason", reason).Msg("item dropped")
 		return errItemFiltered
 	}
+	logs.Debug().Int64("msgid", msgid).Str("channel", url).Str("reason", reason).Msg("item kept")
 
 	rid := ulid.Make().String()
-	if err := store.AddItem(rid, item); err != nil {
+	if err := store.AddItem(rid, item); errors.Is(err, store.ErrItemExisted) || errors.Is(err, store.ErrItemMerged) {
+		return nil
+	} else if err != nil {
 		logs.Warn(err).Rid(rid).Int64("msgid", msgid).Str("channel", url).Msg("add item fail")
 		return err
 	}
-	logs.Debug().Rid(rid).Int64("msgid", msgid).Str("channel", url).Msg("add item succ")
+	logs.Debug().Rid(rid).Int64("msgid", msgid).Str("channel", url).Int("links", len(item.Links)).Msg("add item succ")
+
+	itemHub.Publish(item.Public())
+
+	if subFetcher != nil {
+		subFetcher.Enqueue(rid, item)
+	}
 	return nil
 }
 
-func itemFilter(item *store.SubItem) bool {
-	if strings.Contains(item.MsgContent, "机场") ||
-		strings.Contains(item.MsgContent, "订阅") ||
-		strings.Contains(item.MsgContent, "节点") {
-		return false
+func convEntities(ents []tg.TgEntity) []store.MsgEntity {
+	res := make([]store.MsgEntity, 0, len(ents))
+	for _, e := range ents {
+		res = append(res, store.MsgEntity{
+			Type:   string(e.Type),
+			Offset: e.Offset,
+			Length: e.Length,
+			Url:    e.Url,
+			Lang:   e.Lang,
+		})
+	}
+	return res
+}
+
+func convButtons(btns []tg.TgButton) store.ButtonList {
+	res := make(store.ButtonList, 0, len(btns))
+	for _, b := range btns {
+		res = append(res, store.MsgButton{Text: b.Text, Url: b.Url})
 	}
-	return true
+	return res
 }
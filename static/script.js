@@ -4,12 +4,55 @@ class MessageLoader {
         this.isLoading = false;
         this.hasMore = true;
         this.observer = null;
+        this.query = '';
         this.init();
     }
 
     init() {
+        this.setupSearch();
         this.setupIntersectionObserver();
         this.loadMessages();
+        this.setupStream();
+    }
+
+    // 通过SSE接收新入库的消息，插入到列表顶部；断线后浏览器会自动重连并补发
+    setupStream() {
+        if (!window.EventSource) return;
+
+        const source = new EventSource('/subs/stream');
+        source.addEventListener('item', (e) => {
+            if (this.query) return; // 搜索结果中不插入
+            const item = JSON.parse(e.data);
+            const messageList = document.getElementById('message-list');
+            const card = this.createMessageCard(item);
+            card.classList.add('message-new');
+            messageList.insertBefore(card, messageList.firstChild);
+        });
+        // 断线期间入库的消息太多，服务端不再补发，重新加载列表
+        source.addEventListener('gap', () => {
+            if (this.query) return;
+            this.offset = 0;
+            this.hasMore = true;
+            document.getElementById('message-list').innerHTML = '';
+            document.getElementById('end-marker').classList.remove('visible');
+            this.loadMessages();
+        });
+    }
+
+    setupSearch() {
+        const form = document.getElementById('search-form');
+        if (!form) return;
+
+        form.addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.query = document.getElementById('search-input').value.trim();
+            // 重新从头加载
+            this.offset = 0;
+            this.hasMore = true;
+            document.getElementById('message-list').innerHTML = '';
+            document.getElementById('end-marker').classList.remove('visible');
+            this.loadMessages();
+        });
     }
 
     setupIntersectionObserver() {
@@ -45,19 +88,24 @@ class MessageLoader {
 
         this.isLoading = true;
         this.showLoading(true);
+        let scanMore = false;
 
         try {
             const pageSize = this.getPageSize();
             // 第一次加载时offset为0，之后使用API返回的offset
             const currentOffset = this.offset === 0 ? 0 : this.offset;
-            const url = `/subs/list?offset=${currentOffset}&number=${pageSize}`;
+            const url = this.query
+                ? `/subs/search?q=${encodeURIComponent(this.query)}&offset=${currentOffset}&number=${pageSize}`
+                : `/subs/list?offset=${currentOffset}&number=${pageSize}`;
             
             const response = await fetch(url);
             const data = await response.json();
 
-            if (data.rtn === 0 && data.items) {
-                this.renderMessages(data.items);
+            if (data.rtn === 0 && (data.items || this.query)) {
+                this.renderMessages(data.items || []);
                 this.offset = data.offset;
+                // 搜索时一页可能没有结果，但还没扫描完，需要继续加载
+                scanMore = this.query && !data.items && data.offset >= 0;
                 
                 if (data.offset < 0) {
                     this.hasMore = false;
@@ -72,6 +120,10 @@ class MessageLoader {
             this.isLoading = false;
             this.showLoading(false);
         }
+
+        if (scanMore) {
+            this.loadMessages();
+        }
     }
 
     getPageSize() {
@@ -104,6 +156,12 @@ class MessageLoader {
         content = content.replace(/&#39;/g, "'");
         
         messageContent.innerHTML = content;
+
+        // 消息下方的链接按钮
+        const messageButtons = this.createButtonList(item.buttons);
+
+        // 提取出的订阅链接/节点分享链接
+        const messageLinks = this.createLinkList(item.links);
         
         // 2. 在下方展示抓取时间
         const messageDate = document.createElement('div');
@@ -111,6 +169,17 @@ class MessageLoader {
         // 将时间戳转换为可读格式
         const readableDate = item.date ? this.formatTimestamp(item.date) : '未知时间';
         messageDate.textContent = `抓取时间：${readableDate}`;
+
+        // 订阅检测状态
+        if (item.status) {
+            const badge = document.createElement('span');
+            badge.className = `sub-status sub-status-${item.status}`;
+            badge.textContent = { alive: '可用', dead: '失效', expired: '已过期' }[item.status] || item.status;
+            if (item.checked_at) {
+                badge.title = `检测时间：${this.formatTimestamp(item.checked_at)}`;
+            }
+            messageDate.appendChild(badge);
+        }
         
         // 3. 展示 name 字段作为超链接指向 url
         const channelInfo = document.createElement('div');
@@ -129,14 +198,91 @@ class MessageLoader {
         channelLink.textContent = item.name || '未知频道';
         
         channelInfo.appendChild(channelLink);
+
+        // 其他频道转发的相同内容
+        const others = (item.sources || []).filter(src => src.url !== item.url || src.msgid !== item.msgid);
+        if (others.length > 0) {
+            const sources = document.createElement('span');
+            sources.className = 'channel-sources';
+            sources.textContent = `另见：`;
+            others.forEach(src => {
+                const a = document.createElement('a');
+                a.href = `https://${src.url}/${src.msgid}`;
+                a.target = '_blank';
+                a.rel = 'noopener noreferrer';
+                a.textContent = src.name || src.url;
+                sources.appendChild(a);
+            });
+            channelInfo.insertBefore(sources, channelLink);
+        }
         
         card.appendChild(messageContent);
+        if (messageButtons) {
+            card.appendChild(messageButtons);
+        }
+        if (messageLinks) {
+            card.appendChild(messageLinks);
+        }
         card.appendChild(messageDate);
         card.appendChild(channelInfo);
         
         return card;
     }
 
+    createButtonList(buttons) {
+        if (!buttons || buttons.length === 0) {
+            return null;
+        }
+
+        const box = document.createElement('div');
+        box.className = 'message-buttons';
+
+        buttons.forEach(btn => {
+            const a = document.createElement('a');
+            a.href = btn.url;
+            a.target = '_blank';
+            a.rel = 'noopener noreferrer';
+            a.title = btn.url;
+            a.textContent = btn.text || btn.url;
+            box.appendChild(a);
+        });
+        return box;
+    }
+
+    createLinkList(links) {
+        if (!links || links.length === 0) {
+            return null;
+        }
+
+        const list = document.createElement('ul');
+        list.className = 'message-links';
+
+        links.forEach(link => {
+            const li = document.createElement('li');
+
+            const tag = document.createElement('span');
+            tag.className = 'link-type';
+            tag.textContent = link.type;
+            li.appendChild(tag);
+
+            if (link.type === 'url') {
+                const a = document.createElement('a');
+                a.href = link.url;
+                a.target = '_blank';
+                a.rel = 'noopener noreferrer';
+                a.textContent = link.url;
+                li.appendChild(a);
+            } else {
+                // 分享链接不能直接打开，展示为文本方便复制
+                const code = document.createElement('code');
+                code.textContent = link.url;
+                li.appendChild(code);
+            }
+            list.appendChild(li);
+        });
+        return list;
+    }
+
     showLoading(show) {
         const loading = document.getElementById('loading');
         if (loading) {